@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Budget limits what a run, or a single stage across all of its loop
+// iterations and retries, may spend. Zero fields are unlimited.
+type Budget struct {
+	MaxDuration string  `json:"maxDuration,omitempty"` // Wall time of the run, or backend time of the stage ("30m")
+	MaxCalls    int     `json:"maxCalls,omitempty"`    // Backend calls
+	MaxTokens   int     `json:"maxTokens,omitempty"`   // Estimated prompt and reply tokens
+	MaxCost     float64 `json:"maxCost,omitempty"`     // Estimated cost, from the backends' costPer1kTokens
+	WarnAt      float64 `json:"warnAt,omitempty"`      // Fraction of a limit that prints a warning (default 0.8)
+	OnExceeded  string  `json:"onExceeded,omitempty"`  // "pause" (default), "stop" or "downgrade"
+	Fallback    string  `json:"fallback,omitempty"`    // Backend used from then on by "downgrade"
+}
+
+// errStopped ends a run without failing it: the checkpoint is saved as
+// stopped and the run can be resumed.
+var errStopped = errors.New("run stopped")
+
+func (b *Budget) check() error {
+	if b.MaxDuration != "" {
+		if _, err := time.ParseDuration(b.MaxDuration); err != nil {
+			return fmt.Errorf("budget maxDuration: %w", err)
+		}
+	}
+	if b.MaxCalls < 0 || b.MaxTokens < 0 || b.MaxCost < 0 {
+		return fmt.Errorf("budget limits can't be negative")
+	}
+	if b.WarnAt < 0 || b.WarnAt >= 1 {
+		return fmt.Errorf("budget warnAt must be between 0 and 1")
+	}
+	switch b.OnExceeded {
+	case "", "pause", "stop":
+	case "downgrade":
+		if b.Fallback == "" {
+			return fmt.Errorf("budget onExceeded downgrade needs a fallback backend")
+		}
+		if _, ok := config.Backends[b.Fallback]; !ok {
+			return fmt.Errorf("budget fallback: unknown backend %s", b.Fallback)
+		}
+	default:
+		return fmt.Errorf("budget onExceeded: expected pause, stop or downgrade, got %q", b.OnExceeded)
+	}
+	return nil
+}
+
+func (b *Budget) String() string {
+	var parts []string
+	if b.MaxDuration != "" {
+		parts = append(parts, "time "+b.MaxDuration)
+	}
+	if b.MaxCalls > 0 {
+		parts = append(parts, fmt.Sprintf("%d calls", b.MaxCalls))
+	}
+	if b.MaxTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens", b.MaxTokens))
+	}
+	if b.MaxCost > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f", b.MaxCost))
+	}
+	action := b.OnExceeded
+	if action == "" {
+		action = "pause"
+	}
+	if action == "downgrade" {
+		action += " to " + b.Fallback
+	}
+	return strings.Join(parts, ", ") + ", then " + action
+}
+
+func (b *Budget) warnAt() float64 {
+	if b.WarnAt > 0 {
+		return b.WarnAt
+	}
+	return 0.8
+}
+
+// Usage is what a run or a stage has spent so far.
+type Usage struct {
+	Calls    int           `json:"calls"`
+	Tokens   int           `json:"tokens"`
+	Cost     float64       `json:"cost,omitempty"`
+	Duration time.Duration `json:"duration"` // Time spent waiting on backends
+}
+
+// RunUsage is the part of the checkpoint that carries budgets across
+// resumes.
+type RunUsage struct {
+	Total      Usage             `json:"total"`
+	Stages     map[string]*Usage `json:"stages,omitempty"`
+	Elapsed    time.Duration     `json:"elapsed"`              // Wall time, not counting interruptions
+	Waived     map[string]bool   `json:"waived,omitempty"`     // Limits the run has been allowed past
+	Downgrades map[string]string `json:"downgrades,omitempty"` // Stage ("" for the whole run) -> fallback backend
+}
+
+// budgetMu guards every meter; parallel branches and sub-workflows charge
+// the same meters from several goroutines.
+var budgetMu sync.Mutex
+
+// meter tracks a run's usage against its budgets. Context copies made for
+// parallel branches and gate re-runs share their run's meter, and a
+// sub-workflow's meter also charges the stage of the parent that runs it.
+type meter struct {
+	RunUsage
+	wf          *Workflow
+	since       time.Time // Start of this session, for Elapsed
+	warned      map[string]bool
+	asking      map[string]bool // Limits a pause question is pending for
+	parent      *meter
+	parentStage string
+}
+
+func newMeter(wf *Workflow, saved *RunUsage) *meter {
+	m := &meter{wf: wf, since: time.Now(), warned: make(map[string]bool), asking: make(map[string]bool)}
+	if saved != nil {
+		m.RunUsage = *saved
+	}
+	if m.Stages == nil {
+		m.Stages = make(map[string]*Usage)
+	}
+	if m.Waived == nil {
+		m.Waived = make(map[string]bool)
+	}
+	if m.Downgrades == nil {
+		m.Downgrades = make(map[string]string)
+	}
+	return m
+}
+
+// under makes the meter also charge stage of the parent run, for
+// sub-workflows.
+func (m *meter) under(parent *meter, stage string) {
+	m.parent, m.parentStage = parent, stage
+}
+
+func (m *meter) elapsed() time.Duration {
+	return m.Elapsed + time.Since(m.since)
+}
+
+// snapshot is the usage to save in the checkpoint.
+func (m *meter) snapshot() *RunUsage {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+	u := m.RunUsage
+	u.Elapsed = m.elapsed()
+	u.Stages = make(map[string]*Usage, len(m.Stages))
+	for k, v := range m.Stages {
+		c := *v
+		u.Stages[k] = &c
+	}
+	u.Waived = make(map[string]bool, len(m.Waived))
+	for k, v := range m.Waived {
+		u.Waived[k] = v
+	}
+	u.Downgrades = make(map[string]string, len(m.Downgrades))
+	for k, v := range m.Downgrades {
+		u.Downgrades[k] = v
+	}
+	return &u
+}
+
+// budgetScope is one budget a call counts against.
+type budgetScope struct {
+	m      *meter
+	stage  string // "" for the whole run
+	label  string
+	budget *Budget
+	usage  *Usage
+	time   time.Duration
+}
+
+// scopes lists the budgets a call of stage counts against, innermost
+// first: the stage, its run, then the parent stage and run of a
+// sub-workflow. Scopes without a budget are included so usage is still
+// recorded. Callers hold budgetMu.
+func (m *meter) scopes(stage string) []budgetScope {
+	var out []budgetScope
+	for ; m != nil; stage, m = m.parentStage, m.parent {
+		if stage != "" {
+			u := m.Stages[stage]
+			if u == nil {
+				u = &Usage{}
+				m.Stages[stage] = u
+			}
+			var sb *Budget
+			for i := range m.wf.Stages {
+				if m.wf.Stages[i].Name == stage {
+					sb = m.wf.Stages[i].Budget
+				}
+			}
+			out = append(out, budgetScope{m: m, stage: stage, label: "stage " + stage, budget: sb, usage: u, time: u.Duration})
+		}
+		out = append(out, budgetScope{m: m, label: "workflow " + m.wf.Key, budget: m.wf.Budget, usage: &m.Total, time: m.elapsed()})
+	}
+	return out
+}
+
+// limit is one limit of a budget and how much of it is used.
+type limit struct {
+	kind      string
+	used, max float64
+}
+
+func (l limit) String() string {
+	switch l.kind {
+	case "time":
+		return fmt.Sprintf("time %s of %s", formatDuration(time.Duration(l.used*float64(time.Second))), formatDuration(time.Duration(l.max*float64(time.Second))))
+	case "cost":
+		return fmt.Sprintf("cost $%.2f of $%.2f", l.used, l.max)
+	}
+	return fmt.Sprintf("%s %d of %d", l.kind, int(l.used), int(l.max))
+}
+
+func (s *budgetScope) limits() []limit {
+	b := s.budget
+	if b == nil {
+		return nil
+	}
+	var ls []limit
+	if d, err := time.ParseDuration(b.MaxDuration); err == nil && d > 0 {
+		ls = append(ls, limit{"time", s.time.Seconds(), d.Seconds()})
+	}
+	if b.MaxCalls > 0 {
+		ls = append(ls, limit{"calls", float64(s.usage.Calls), float64(b.MaxCalls)})
+	}
+	if b.MaxTokens > 0 {
+		ls = append(ls, limit{"tokens", float64(s.usage.Tokens), float64(b.MaxTokens)})
+	}
+	if b.MaxCost > 0 {
+		ls = append(ls, limit{"cost", s.usage.Cost, b.MaxCost})
+	}
+	return ls
+}
+
+func (s *budgetScope) key(l limit) string {
+	return s.label + ":" + l.kind
+}
+
+// before enforces the budgets of stage ahead of a backend call. It returns
+// the backend and model to use (a downgrade replaces them) and how long
+// the call may take before a time limit runs out (0 for no limit).
+func (m *meter) before(stage, backend, model string) (string, string, time.Duration, error) {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+
+	scopes := m.scopes(stage)
+	for i := range scopes {
+		s := &scopes[i]
+		for _, l := range s.limits() {
+			key := s.key(l)
+			if l.used < l.max || s.m.Waived[key] || s.m.asking[key] {
+				continue
+			}
+			desc := fmt.Sprintf("%s: %s", s.label, l)
+			switch s.budget.OnExceeded {
+			case "stop":
+				s.m.Waived[key] = true
+				return "", "", 0, fmt.Errorf("%w: budget exceeded (%s)", errStopped, desc)
+			case "downgrade":
+				s.m.Waived[key] = true
+				s.m.Downgrades[s.stage] = s.budget.Fallback
+				fmt.Printf("%s Budget exceeded (%s), switching to %s\n", yellow("!"), desc, s.budget.Fallback)
+			default:
+				// Other branches keep going while this waits for an answer,
+				// without asking again. The waiver only counts once the
+				// user agrees, so a run stopped here asks again on resume.
+				q := fmt.Sprintf("%s Budget exceeded (%s). Continue anyway? [y/N]: ", yellow("?"), desc)
+				s.m.asking[key] = true
+				budgetMu.Unlock()
+				answer := ask(q, "n")
+				budgetMu.Lock()
+				delete(s.m.asking, key)
+				if answer != "y" {
+					return "", "", 0, fmt.Errorf("%w: budget exceeded (%s)", errStopped, desc)
+				}
+				s.m.Waived[key] = true
+			}
+		}
+	}
+
+	// The innermost downgrade wins
+	for _, s := range scopes {
+		if fb, ok := s.m.Downgrades[s.stage]; ok {
+			backend, model = fb, ""
+			break
+		}
+	}
+
+	var timeout time.Duration
+	for i := range scopes {
+		for _, l := range scopes[i].limits() {
+			if l.kind != "time" || scopes[i].m.Waived[scopes[i].key(l)] {
+				continue
+			}
+			left := time.Duration((l.max - l.used) * float64(time.Second))
+			if timeout == 0 || left < timeout {
+				timeout = left
+			}
+		}
+	}
+	return backend, model, timeout, nil
+}
+
+// record charges a finished call to every scope of stage and warns about
+// limits that are getting close.
+func (m *meter) record(stage, backend, prompt, reply string, took time.Duration) {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+
+	// Backends don't report usage, so tokens are estimated at ~4 characters
+	tokens := (len(prompt) + len(reply) + 3) / 4
+	cost := float64(tokens) / 1000 * config.Backends[backend].CostPer1kTokens
+
+	scopes := m.scopes(stage)
+	for i := range scopes {
+		s := &scopes[i]
+		s.usage.Calls++
+		s.usage.Tokens += tokens
+		s.usage.Cost += cost
+		s.usage.Duration += took
+		if s.stage != "" {
+			s.time = s.usage.Duration
+		}
+		for _, l := range s.limits() {
+			k := s.key(l)
+			if s.m.Waived[k] || s.m.warned[k] {
+				continue
+			}
+			switch {
+			case l.used >= l.max:
+				s.m.warned[k] = true
+				fmt.Printf("%s Budget: %s reached its limit (%s)\n", yellow("!"), s.label, l)
+			case l.used >= l.max*s.budget.warnAt():
+				s.m.warned[k] = true
+				fmt.Printf("%s Budget: %s has used %d%% of its limit (%s)\n", yellow("!"), s.label, int(l.used/l.max*100), l)
+			}
+		}
+	}
+}
+
+// callStage makes one backend call for the stage being run, within its
+// budgets. A call cut short by a time limit is retried once the limit has
+// been dealt with (continued past or downgraded), or stops the run.
+func (ctx *WorkflowContext) callStage(backend, model, prompt string, interactive bool) (string, error) {
+	if ctx == nil || ctx.Meter == nil {
+		if interactive {
+			return callInteractiveBackend(backend, model, prompt), nil
+		}
+		return callBackend(backend, model, prompt, ctx.output())
+	}
+	for {
+		b, mdl, timeout, err := ctx.Meter.before(ctx.Running, backend, model)
+		if err != nil {
+			return "", err
+		}
+		start := time.Now()
+		var reply string
+		timedOut := false
+		if interactive {
+			reply = callInteractiveBackend(b, mdl, prompt)
+		} else {
+			reply, timedOut, err = callBackendTimeout(b, mdl, prompt, ctx.output(), timeout)
+		}
+		ctx.Meter.record(ctx.Running, b, prompt, reply, time.Since(start))
+		if ctx.Manifest != nil {
+			call := callRecord(b, mdl, prompt, interactive, start, timedOut, err)
+			call.Prompt = ctx.savePrompt(prompt)
+			ctx.Manifest.call(ctx.Running, call)
+		}
+		if !timedOut {
+			return reply, err
+		}
+		fmt.Fprintf(ctx.output(), "%s Call stopped by the time budget\n", yellow("!"))
+	}
+}
+
+// formatUsage is a one-line summary for the end of a run.
+func formatUsage(u Usage) string {
+	s := fmt.Sprintf("%d calls, ~%d tokens", u.Calls, u.Tokens)
+	if u.Cost > 0 {
+		s += fmt.Sprintf(", ~$%.2f", u.Cost)
+	}
+	return s
+}
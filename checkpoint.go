@@ -2,34 +2,83 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
+)
+
+const (
+	statusRunning   = "running"
+	statusCompleted = "completed"
+	statusStopped   = "stopped"
+	statusFailed    = "failed"
 )
 
 type WorkflowState struct {
 	WorkflowName   string            `json:"workflow"`
 	Requirement    string            `json:"requirement"`
-	CurrentStage   int               `json:"currentStage"`
+	CurrentStage   int               `json:"currentStage"`        // Stage before NextStage
+	NextStage      *int              `json:"nextStage,omitempty"` // Stage to resume from
+	Status         string            `json:"status,omitempty"`
 	Results        map[string]string `json:"results"`
 	WorkDir        string            `json:"workDir"`
-	ReviewAttempts int               `json:"reviewAttempts,omitempty"`
-}
-
-func saveCheckpoint(ctx *WorkflowContext, wfName string, stageIdx int) {
-	saveCheckpointWithAttempts(ctx, wfName, stageIdx, 0)
+	ReviewAttempts int               `json:"reviewAttempts,omitempty"` // Deprecated: read into Loops["review"]
+	Loops          map[string]int    `json:"loops,omitempty"`
+	Skipped        []string          `json:"skipped,omitempty"`
+	Timings        []time.Duration   `json:"timings,omitempty"` // Completed stage durations
+	Baseline       map[string]string `json:"baseline"`          // File hashes before the run
+	StartedAt      time.Time         `json:"startedAt,omitempty"`
+	Backend        string            `json:"backend,omitempty"`    // Session backend
+	Definition     *Workflow         `json:"definition,omitempty"` // Workflow as it was when the run started
+	Nodes          map[string]string `json:"nodes,omitempty"`      // Per-stage status for DAG workflows
+	Outputs        map[string]string `json:"outputs,omitempty"`    // Stage name -> saved output file
+	Gates          []GateDecision    `json:"gates,omitempty"`      // Approval gate decisions
+	Params         map[string]string `json:"params,omitempty"`     // Workflow parameter values
+	Usage          *RunUsage         `json:"usage,omitempty"`      // Spending against budgets
+	Revisions      []Revision        `json:"revisions,omitempty"`  // Restarts at a stage
+	Lineage        *Lineage          `json:"lineage,omitempty"`    // Run this one was forked from
 }
 
-func saveCheckpointWithAttempts(ctx *WorkflowContext, wfName string, stageIdx int, reviewAttempts int) {
+// saveCheckpoint records the full run state so resumeWorkflow can continue
+// from stage next as if the run had never stopped.
+func saveCheckpoint(ctx *WorkflowContext, wf *Workflow, next int, status string) {
+	last := next - 1
+	if last < 0 {
+		last = 0
+	}
 	state := WorkflowState{
-		WorkflowName:   wfName,
-		Requirement:    ctx.Requirement,
-		CurrentStage:   stageIdx,
-		Results:        ctx.Results,
-		WorkDir:        ctx.WorkDir,
-		ReviewAttempts: reviewAttempts,
+		WorkflowName: wf.Key,
+		Requirement:  ctx.Requirement,
+		CurrentStage: last,
+		NextStage:    &next,
+		Status:       status,
+		Results:      ctx.Results,
+		WorkDir:      ctx.WorkDir,
+		Loops:        ctx.Loops,
+		Skipped:      ctx.Skipped,
+		StartedAt:    ctx.StartedAt,
+		Backend:      ctx.Backend,
+		Definition:   wf,
+		Nodes:        ctx.Nodes,
+		Outputs:      ctx.Outputs,
+		Gates:        ctx.Gates,
+		Params:       ctx.Params,
+		Revisions:    ctx.Revisions,
+		Lineage:      ctx.Lineage,
+	}
+	if ctx.Timer != nil {
+		state.Timings = ctx.Timer.Stages
+	}
+	if ctx.Meter != nil {
+		state.Usage = ctx.Meter.snapshot()
+	}
+	if ctx.BeforeSnapshot != nil {
+		state.Baseline = ctx.BeforeSnapshot.Files
 	}
 	data, _ := json.MarshalIndent(state, "", "  ")
-	os.WriteFile(filepath.Join(ctx.WorkDir, "state.json"), data, 0644)
+	writeFileAtomic(filepath.Join(ctx.WorkDir, "state.json"), data, 0644)
+	ctx.Manifest.write(ctx, wf, status)
 }
 
 func loadCheckpoint(workDir string) (*WorkflowState, error) {
@@ -44,6 +93,92 @@ func loadCheckpoint(workDir string) (*WorkflowState, error) {
 	return &state, nil
 }
 
+// restoreContext rebuilds the run context saved by saveCheckpoint.
+func restoreContext(state *WorkflowState, logFile *os.File) *WorkflowContext {
+	ctx := &WorkflowContext{
+		Requirement: state.Requirement,
+		WorkDir:     state.WorkDir,
+		Results:     state.Results,
+		LogFile:     logFile,
+		Loops:       state.Loops,
+		Skipped:     state.Skipped,
+		Timer:       restoreStageTimer(state.Timings),
+		StartedAt:   state.StartedAt,
+		Backend:     state.Backend,
+		Nodes:       state.Nodes,
+		Outputs:     state.Outputs,
+		Gates:       state.Gates,
+		Params:      state.Params,
+		Revisions:   state.Revisions,
+		Lineage:     state.Lineage,
+	}
+	if ctx.Results == nil {
+		ctx.Results = make(map[string]string)
+	}
+	if ctx.Loops == nil {
+		ctx.Loops = make(map[string]int)
+		if state.ReviewAttempts > 0 {
+			ctx.Loops["review"] = state.ReviewAttempts
+		}
+	}
+	if state.Baseline != nil {
+		ctx.BeforeSnapshot = &FileSnapshot{Files: state.Baseline}
+	} else {
+		ctx.BeforeSnapshot = takeSnapshot()
+	}
+	return ctx
+}
+
+// newRunDir creates the directory of a new run and points latest at it.
+func newRunDir() string {
+	workDir := makeRunDir()
+	pointLatest(workDir)
+	return workDir
+}
+
+// makeRunDir creates the directory of a new run.
+func makeRunDir() string {
+	baseDir := ".workflow"
+	name := time.Now().Format("20060102_150405")
+	// Runs started within the same second get a suffix
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(baseDir, name)); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s_%d", time.Now().Format("20060102_150405"), n)
+	}
+	workDir := filepath.Join(baseDir, name)
+	os.MkdirAll(workDir, 0755)
+	return workDir
+}
+
+// pointLatest moves the .workflow/latest link to workDir.
+func pointLatest(workDir string) {
+	latestLink := filepath.Join(filepath.Dir(workDir), "latest")
+	os.Remove(latestLink)
+	os.Symlink(filepath.Base(workDir), latestLink)
+}
+
+// findRunDir resolves a run given as a folder name, a path, or "" for the
+// latest run.
+func findRunDir(folder string) (string, error) {
+	if folder == "" {
+		if dir := findLatestWorkflow(); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("no workflow to resume")
+	}
+	// Check if it's a full path or just folder name
+	workDir := folder
+	if !filepath.IsAbs(folder) {
+		workDir = filepath.Join(".workflow", folder)
+	}
+	if _, err := os.Stat(workDir); os.IsNotExist(err) {
+		return "", fmt.Errorf("workflow folder not found: %s", workDir)
+	}
+	return workDir, nil
+}
+
 func findLatestWorkflow() string {
 	latestPath := filepath.Join(".workflow", "latest")
 	if target, err := os.Readlink(latestPath); err == nil {
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Exit codes of `proxy workflow run`.
+const (
+	exitOK      = 0
+	exitFailed  = 1 // A stage failed
+	exitStopped = 2 // Stopped before the end (loop limit, gate)
+	exitUsage   = 3 // Unknown workflow, invalid definition or arguments
+)
+
+// runOptions answers the questions a workflow would otherwise ask on the
+// terminal. The zero value is the normal interactive behaviour.
+type runOptions struct {
+	NonInteractive bool
+	Yes            bool   // Approve gates
+	SkipOptional   bool   // Answer yes to "Skip this stage?"
+	OnExhausted    string // Loops that would ask: "stop" (default), "continue" or "fail"
+	Interactive    string // Interactive stages: "headless" (default) runs them with the prompt flag, "fail" refuses
+}
+
+var runOpts runOptions
+
+// ask prints a question and returns the lowercased answer. In
+// non-interactive mode the given answer is printed and used instead.
+func ask(question, headless string) string {
+	fmt.Print(question)
+	if runOpts.NonInteractive {
+		fmt.Printf("%s %s\n", headless, dim("(non-interactive)"))
+		return headless
+	}
+	var input string
+	fmt.Scanln(&input)
+	return strings.ToLower(strings.TrimSpace(input))
+}
+
+// askSkip asks whether to skip a skippable stage.
+func askSkip(question string) bool {
+	answer := "n"
+	if runOpts.SkipOptional {
+		answer = "y"
+	}
+	return ask(question, answer) == "y"
+}
+
+// interactiveStage reports whether an interactive stage should get the
+// terminal, or fails if non-interactive mode refuses such stages.
+func interactiveStage(name string) (bool, error) {
+	if !runOpts.NonInteractive {
+		return true, nil
+	}
+	if runOpts.Interactive == "fail" {
+		return false, fmt.Errorf("stage %s is interactive (use --interactive-stages headless)", name)
+	}
+	return false, nil
+}
+
+func (o *runOptions) check() error {
+	switch o.OnExhausted {
+	case "", "stop", "continue", "fail":
+	default:
+		return fmt.Errorf("--on-loop-exhausted: expected stop, continue or fail, got %q", o.OnExhausted)
+	}
+	switch o.Interactive {
+	case "", "headless", "fail":
+	default:
+		return fmt.Errorf("--interactive-stages: expected headless or fail, got %q", o.Interactive)
+	}
+	return nil
+}
+
+// RunSummary is the machine-readable result of `proxy workflow run`.
+type RunSummary struct {
+	Workflow    string            `json:"workflow"`
+	Requirement string            `json:"requirement"`
+	Params      map[string]string `json:"params,omitempty"`
+	Status      string            `json:"status"`
+	ExitCode    int               `json:"exitCode"`
+	Error       string            `json:"error,omitempty"`
+	RunDir      string            `json:"runDir,omitempty"`
+	StartedAt   time.Time         `json:"startedAt,omitempty"`
+	Duration    string            `json:"duration"`
+	Stages      []StageSummary    `json:"stages"`
+	Gates       []GateDecision    `json:"gates,omitempty"`
+	Usage       *RunUsage         `json:"usage,omitempty"`
+	Manifest    string            `json:"manifest,omitempty"` // Full per-stage record of the run
+}
+
+type StageSummary struct {
+	Name     string                 `json:"name"`
+	Status   string                 `json:"status"` // done, skipped, failed or pending
+	Output   string                 `json:"output,omitempty"`
+	Verdict  map[string]interface{} `json:"verdict,omitempty"`
+	Backend  string                 `json:"backend,omitempty"` // Backend of the last call, after any downgrade
+	Attempts int                    `json:"attempts,omitempty"`
+	Duration string                 `json:"duration,omitempty"` // Of the last attempt
+}
+
+// summarizeRun builds the summary of the run that just finished in dir
+// from its checkpoint.
+func summarizeRun(wf *Workflow, dir, requirement string, started time.Time, runErr error) RunSummary {
+	sum := RunSummary{
+		Workflow:    wf.Key,
+		Requirement: requirement,
+		Status:      statusCompleted,
+		Duration:    time.Since(started).Round(time.Millisecond).String(),
+	}
+	if runErr != nil {
+		sum.Status = statusFailed
+		sum.Error = runErr.Error()
+	}
+
+	var state *WorkflowState
+	if dir != "" {
+		state, _ = loadCheckpoint(dir)
+	}
+	if state == nil {
+		// The run never got as far as a checkpoint
+		sum.ExitCode = exitUsage
+		if runErr == nil {
+			sum.ExitCode = exitOK
+		}
+		return sum
+	}
+	sum.RunDir = dir
+	sum.StartedAt = state.StartedAt
+	sum.Status = state.Status
+	sum.Gates = state.Gates
+	sum.Params = state.Params
+	sum.Usage = state.Usage
+
+	records := make(map[string]StageRecord)
+	if m, err := loadManifest(dir); err == nil {
+		records = m.lastRecords()
+		sum.Manifest = filepath.Join(dir, "manifest.json")
+	}
+
+	ctx := &WorkflowContext{Results: state.Results, Workflow: wf}
+	skipped := make(map[string]bool)
+	for _, s := range state.Skipped {
+		skipped[s] = true
+	}
+	for i, s := range wf.Stages {
+		st := StageSummary{Name: s.Name, Status: "pending", Output: state.Outputs[s.Name]}
+		switch {
+		case state.Nodes[s.Name] != "":
+			st.Status = state.Nodes[s.Name]
+		case skipped[s.Name]:
+			st.Status = nodeSkipped
+		case hasResult(state.Results, s.Name):
+			st.Status = nodeDone
+		case state.Status == statusFailed && state.NextStage != nil && *state.NextStage == i:
+			st.Status = nodeFailed
+		}
+		st.Verdict = ctx.verdict(s.Name)
+		if r, ok := records[s.Name]; ok && r.Status != nodeSkipped {
+			st.Attempts = r.Attempt
+			st.Duration = r.Duration.Round(time.Millisecond).String()
+			if len(r.Calls) > 0 {
+				st.Backend = r.Calls[len(r.Calls)-1].Backend
+			}
+		}
+		sum.Stages = append(sum.Stages, st)
+	}
+
+	switch sum.Status {
+	case statusCompleted:
+		sum.ExitCode = exitOK
+	case statusStopped:
+		sum.ExitCode = exitStopped
+	default:
+		sum.ExitCode = exitFailed
+	}
+	return sum
+}
+
+func hasResult(results map[string]string, name string) bool {
+	_, ok := results[name]
+	return ok
+}
+
+// runWorkflowHeadless is `proxy workflow run`: it runs one workflow without
+// the REPL, writes the summary and returns the process exit code.
+func runWorkflowHeadless(name, requirement string, vars []string, paramsFile, summaryPath string) int {
+	if err := runOpts.check(); err != nil {
+		fmt.Printf("%s %v\n", red("Error:"), err)
+		return exitUsage
+	}
+	if _, ok := config.Backends[current]; !ok {
+		fmt.Printf("%s Unknown backend: %s\n", red("Error:"), current)
+		return exitUsage
+	}
+	loadProjectConfig()
+	loadSkills()
+
+	wf := getWorkflow(name)
+	if wf == nil {
+		fmt.Printf("%s Unknown workflow: %s\n", red("Error:"), name)
+		return exitUsage
+	}
+	if requirement == "" {
+		fmt.Printf("%s missing requirement\n", red("Error:"))
+		return exitUsage
+	}
+	if err := wf.validate(); err != nil {
+		fmt.Printf("%s workflow %s: %v\n", red("Error:"), wf.Key, err)
+		return exitUsage
+	}
+
+	params, err := parseVars(vars)
+	if err == nil && paramsFile != "" {
+		err = readParamsFile(paramsFile, params)
+	}
+	if err == nil {
+		// Resolve here so a missing value is a usage error, not a failed run.
+		// Run gets every value, so it has nothing left to ask for.
+		params, err = wf.resolveParams(params, !runOpts.NonInteractive)
+	}
+	if err != nil {
+		fmt.Printf("%s %v\n", red("Error:"), err)
+		return exitUsage
+	}
+
+	started := time.Now()
+	dir, runErr := wf.Run(requirement, params)
+	if runErr != nil {
+		fmt.Printf("%s %v\n", red("Error:"), runErr)
+	}
+	sum := summarizeRun(wf, dir, requirement, started, runErr)
+
+	data, _ := json.MarshalIndent(sum, "", "  ")
+	if sum.RunDir != "" {
+		writeFileAtomic(filepath.Join(sum.RunDir, "summary.json"), data, 0644)
+	}
+	switch summaryPath {
+	case "":
+	case "-":
+		fmt.Println(string(data))
+	default:
+		if err := writeFileAtomic(summaryPath, data, 0644); err != nil {
+			fmt.Printf("%s %v\n", red("Error:"), err)
+		}
+	}
+	return sum.ExitCode
+}
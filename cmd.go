@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -11,6 +12,14 @@ var (
 	flagBackend string
 	flagList    bool
 	flagInit    bool
+	flagWatch   bool
+
+	flagSummary    string
+	flagVars       []string
+	flagParamsFile string
+
+	flagMigrateDryRun bool
+	flagMigrateYes    bool
 )
 
 var rootCmd = &cobra.Command{
@@ -60,10 +69,111 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+var workflowCmd = &cobra.Command{
+	Use:   "workflow",
+	Short: "Run and inspect workflows",
+}
+
+var workflowRunCmd = &cobra.Command{
+	Use:   "run <name> <requirement...>",
+	Short: "Run a workflow without the interactive shell",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config = loadConfig()
+		current = config.Default
+		if flagBackend != "" {
+			current = flagBackend
+		}
+		os.Exit(runWorkflowHeadless(args[0], strings.Join(args[1:], " "), flagVars, flagParamsFile, flagSummary))
+	},
+}
+
+var workflowReportCmd = &cobra.Command{
+	Use:   "report [run]",
+	Short: "Write a Markdown and HTML report of a run (default: the latest)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config = loadConfig()
+		folder := ""
+		if len(args) > 0 {
+			folder = args[0]
+		}
+		md, html, err := writeReport(folder)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(md)
+		fmt.Println(html)
+	},
+}
+
+var workflowHistoryCmd = &cobra.Command{
+	Use:                "history [show <run> | compare <a> <b> [stage] | prune ...] [filters]",
+	Short:              "List, inspect, compare and prune past runs",
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		config = loadConfig()
+		historyCommand(args)
+	},
+}
+
+var workflowLintCmd = &cobra.Command{
+	Use:   "lint [name...]",
+	Short: "Check workflow definitions for mistakes (default: all workflows)",
+	Run: func(cmd *cobra.Command, args []string) {
+		config = loadConfig()
+		loadProjectConfig()
+		loadSkills()
+		if !lintWorkflows(args) {
+			os.Exit(1)
+		}
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage ai-proxy config files",
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade global and project config files to the current format",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := migrateOptions{DryRun: flagMigrateDryRun, Yes: flagMigrateYes}
+		if err := migrateConfigFiles(opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
 func init() {
+	configMigrateCmd.Flags().BoolVar(&flagMigrateDryRun, "dry-run", false, "Show what would change without writing")
+	configMigrateCmd.Flags().BoolVarP(&flagMigrateYes, "yes", "y", false, "Write changes without asking")
+	workflowRunCmd.Flags().BoolVar(&runOpts.NonInteractive, "non-interactive", false, "Never read from the terminal; answer prompts from the flags below")
+	workflowRunCmd.Flags().BoolVarP(&runOpts.Yes, "yes", "y", false, "Approve gates")
+	workflowRunCmd.Flags().BoolVar(&runOpts.SkipOptional, "skip-optional", false, "Skip skippable stages instead of running them")
+	workflowRunCmd.Flags().StringVar(&runOpts.OnExhausted, "on-loop-exhausted", "stop", "When a loop would ask to continue: stop, continue or fail")
+	workflowRunCmd.Flags().StringVar(&runOpts.Interactive, "interactive-stages", "headless", "Interactive stages: headless (run with the prompt flag) or fail")
+	workflowRunCmd.Flags().StringArrayVar(&flagVars, "var", nil, "Workflow parameter as key=value (repeatable)")
+	workflowRunCmd.Flags().StringVar(&flagParamsFile, "params", "", "JSON or YAML file with workflow parameters")
+	workflowRunCmd.Flags().StringVar(&flagSummary, "summary", "", "Also write the JSON summary to this file (- for stdout)")
+	workflowRunCmd.Flags().StringVarP(&flagBackend, "backend", "b", "", "Session backend")
+	workflowCmd.AddCommand(workflowRunCmd)
+	workflowCmd.AddCommand(workflowReportCmd)
+	workflowCmd.AddCommand(workflowHistoryCmd)
+	workflowCmd.AddCommand(workflowLintCmd)
+	rootCmd.AddCommand(workflowCmd)
+
+	configCmd.AddCommand(configMigrateCmd)
+	rootCmd.AddCommand(configCmd)
+
 	rootCmd.Flags().StringVarP(&flagBackend, "backend", "b", "", "Backend to use (claude, kiro)")
 	rootCmd.Flags().BoolVarP(&flagList, "list", "l", false, "List available backends")
 	rootCmd.Flags().BoolVar(&flagInit, "init", false, "Initialize project config (.ai-proxy/config.json)")
+	rootCmd.Flags().BoolVar(&flagWatch, "watch", false, "Reload config and skills automatically when they change")
 }
 
 func Execute() {
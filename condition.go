@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Conditions are boolean expressions over atoms such as file:go.mod or
+// verdict:code-review.status=APPROVED, combined with and/or/not (or &&, ||,
+// !) and parentheses:
+//
+//	file:go.mod and not (branch:main or dirty)
+
+type condNode interface {
+	eval(ctx *WorkflowContext) bool
+}
+
+type condNot struct{ x condNode }
+type condAnd struct{ x, y condNode }
+type condOr struct{ x, y condNode }
+
+func (c condNot) eval(ctx *WorkflowContext) bool { return !c.x.eval(ctx) }
+func (c condAnd) eval(ctx *WorkflowContext) bool { return c.x.eval(ctx) && c.y.eval(ctx) }
+func (c condOr) eval(ctx *WorkflowContext) bool  { return c.x.eval(ctx) || c.y.eval(ctx) }
+
+// condAtom is a single test. For comparisons ("env:CI=true",
+// "verdict:review.risk!=high") op is "=" or "!=" and want holds the value.
+type condAtom struct {
+	kind, arg string
+	op, want  string
+}
+
+// condition is a parsed expression plus the stages it refers to, which
+// validate checks against the workflow.
+type condition struct {
+	root   condNode
+	stages []string
+	params []string
+}
+
+type condToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeCondition(s string) ([]condToken, error) {
+	var toks []condToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, condToken{text: string(c)})
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			toks = append(toks, condToken{text: s[i : i+2]})
+			i += 2
+		case c == '!' && !strings.HasPrefix(s[i:], "!="):
+			toks = append(toks, condToken{text: "!"})
+			i++
+		default:
+			var word strings.Builder
+			quoted := false
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) && s[i] != '(' && s[i] != ')' {
+				if s[i] == '"' {
+					end := strings.IndexByte(s[i+1:], '"')
+					if end < 0 {
+						return nil, fmt.Errorf("unterminated quote in %q", s)
+					}
+					word.WriteString(s[i+1 : i+1+end])
+					i += end + 2
+					quoted = true
+					continue
+				}
+				word.WriteByte(s[i])
+				i++
+			}
+			toks = append(toks, condToken{text: word.String(), quoted: quoted})
+		}
+	}
+	return toks, nil
+}
+
+type condParser struct {
+	toks   []condToken
+	pos    int
+	stages []string
+	params []string
+}
+
+func (p *condParser) peek() (condToken, bool) {
+	if p.pos >= len(p.toks) {
+		return condToken{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// isOp reports whether the next token is one of the given operators.
+// Quoted words are never operators.
+func (p *condParser) isOp(ops ...string) bool {
+	t, ok := p.peek()
+	if !ok || t.quoted {
+		return false
+	}
+	for _, op := range ops {
+		if strings.EqualFold(t.text, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCondition parses an expression. Errors name the offending token.
+func parseCondition(s string) (*condition, error) {
+	toks, err := tokenizeCondition(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+	p := &condParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q in %q", t.text, s)
+	}
+	return &condition{root: root, stages: p.stages, params: p.params}, nil
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("or", "||") {
+		p.pos++
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = condOr{x, y}
+	}
+	return x, nil
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("and", "&&") {
+		p.pos++
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = condAnd{x, y}
+	}
+	return x, nil
+}
+
+func (p *condParser) parseUnary() (condNode, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("condition ends unexpectedly")
+	}
+	switch {
+	case p.isOp("not", "!"):
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return condNot{x}, nil
+	case p.isOp("("):
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return x, nil
+	case p.isOp(")", "and", "&&", "or", "||"):
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	p.pos++
+	return p.parseAtom(t.text)
+}
+
+// cutComparison splits "x=v" or "x!=v".
+func cutComparison(s string) (left, op, want string) {
+	if l, r, ok := strings.Cut(s, "!="); ok {
+		return l, "!=", r
+	}
+	if l, r, ok := strings.Cut(s, "="); ok {
+		return l, "=", r
+	}
+	return s, "", ""
+}
+
+func (p *condParser) parseAtom(word string) (condNode, error) {
+	kind, arg, hasArg := strings.Cut(word, ":")
+	a := condAtom{kind: kind, arg: arg}
+	switch kind {
+	case "go", "node", "docker", "dirty", "true", "false":
+		if hasArg {
+			return nil, fmt.Errorf("%s takes no argument", kind)
+		}
+		return a, nil
+	case "file", "has", "glob", "branch":
+	case "env":
+		a.arg, a.op, a.want = cutComparison(arg)
+	case "param":
+		a.arg, a.op, a.want = cutComparison(arg)
+		p.params = append(p.params, a.arg)
+	case "verify":
+		if arg != "passed" && arg != "failed" {
+			return nil, fmt.Errorf("verify:%s: expected verify:passed or verify:failed", arg)
+		}
+		return a, nil
+	case "approved", "passed", "done", "skipped":
+		p.stages = append(p.stages, arg)
+	case "contains":
+		stage, text, ok := strings.Cut(arg, ":")
+		if !ok || text == "" {
+			return nil, fmt.Errorf("%s: expected contains:<stage>:<text>", word)
+		}
+		p.stages = append(p.stages, stage)
+	case "verdict":
+		a.arg, a.op, a.want = cutComparison(arg)
+		stage, field, ok := strings.Cut(a.arg, ".")
+		if !ok || field == "" {
+			return nil, fmt.Errorf("%s: expected verdict:<stage>.<field>", word)
+		}
+		p.stages = append(p.stages, stage)
+	default:
+		return nil, fmt.Errorf("unknown condition %q", word)
+	}
+	if a.arg == "" {
+		return nil, fmt.Errorf("%s: missing argument", word)
+	}
+	return a, nil
+}
+
+func (a condAtom) eval(ctx *WorkflowContext) bool {
+	switch a.kind {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "file":
+		_, err := os.Stat(a.arg)
+		return err == nil
+	case "has":
+		found := false
+		filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+			if strings.HasSuffix(path, a.arg) {
+				found = true
+			}
+			return nil
+		})
+		return found
+	case "glob":
+		matches, _ := filepath.Glob(a.arg)
+		return len(matches) > 0
+	case "go":
+		_, err := os.Stat("go.mod")
+		return err == nil
+	case "node":
+		_, err := os.Stat("package.json")
+		return err == nil
+	case "docker":
+		_, err := os.Stat("Dockerfile")
+		return err == nil
+	case "env":
+		val, set := os.LookupEnv(a.arg)
+		return compare(set && val != "", val, a.op, a.want)
+	case "param":
+		val := ctx.Params[a.arg]
+		if a.op == "" {
+			return val != "" && val != "false"
+		}
+		return compare(val != "", val, a.op, a.want)
+	case "branch":
+		out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+		if err != nil {
+			return false
+		}
+		ok, _ := path.Match(a.arg, strings.TrimSpace(string(out)))
+		return ok
+	case "dirty":
+		// The run directory itself doesn't count as a change
+		out, err := exec.Command("git", "status", "--porcelain", "--", ".", ":!.workflow").Output()
+		return err == nil && len(strings.TrimSpace(string(out))) > 0
+	case "verify":
+		result, ok := ctx.Results["verify"]
+		if !ok {
+			return false
+		}
+		return strings.Contains(result, "ALL PASSED") == (a.arg == "passed")
+	case "approved":
+		if s := ctx.stage(a.arg); s != nil && s.Schema != nil {
+			status, _ := ctx.verdictField(a.arg + ".status")
+			str, _ := status.(string)
+			return strings.EqualFold(str, "APPROVED")
+		}
+		return isApproved(ctx.Results[a.arg])
+	case "passed":
+		return strings.Contains(ctx.Results[a.arg], "ALL PASSED")
+	case "contains":
+		stage, text, _ := strings.Cut(a.arg, ":")
+		return strings.Contains(ctx.Results[stage], text)
+	case "done":
+		_, ok := ctx.Results[a.arg]
+		return ok
+	case "skipped":
+		for _, s := range ctx.Skipped {
+			if s == a.arg {
+				return true
+			}
+		}
+		return false
+	case "verdict":
+		val, ok := ctx.verdictField(a.arg)
+		if a.op == "" {
+			return ok && truthy(val)
+		}
+		return compare(ok && val != nil, fmt.Sprint(val), a.op, a.want)
+	}
+	return false
+}
+
+// compare applies an atom's optional comparison; without one the atom is
+// true when the value is set.
+func compare(set bool, val, op, want string) bool {
+	switch op {
+	case "=":
+		return set && strings.EqualFold(val, want)
+	case "!=":
+		return !set || !strings.EqualFold(val, want)
+	}
+	return set
+}
+
+// checkCondition evaluates a stage condition or loop test. An empty
+// condition holds; one that doesn't parse does not.
+func checkCondition(cond string, ctx *WorkflowContext) bool {
+	if cond == "" {
+		return true
+	}
+	c, err := parseCondition(cond)
+	if err != nil {
+		fmt.Fprintf(ctx.output(), "%s Invalid condition %q: %v\n", red("✗"), cond, err)
+		return false
+	}
+	return c.root.eval(ctx)
+}
+
+// checkConditions parses every condition of a workflow and makes sure the
+// stages and parameters they mention exist.
+func (wf *Workflow) checkConditions() error {
+	known := map[string]bool{"project-context": true, "diff": true, "verify": true}
+	for _, s := range wf.Stages {
+		known[s.Name] = true
+	}
+	check := func(what, cond string) error {
+		if cond == "" {
+			return nil
+		}
+		c, err := parseCondition(cond)
+		if err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		for _, name := range c.stages {
+			// "<stage>/<sub stage>" refers into a sub-workflow's results
+			if parent, _, ok := strings.Cut(name, subResultSep); ok && known[parent] {
+				continue
+			}
+			if !known[name] {
+				return fmt.Errorf("%s: unknown stage %s in %q", what, name, cond)
+			}
+		}
+		for _, name := range c.params {
+			if wf.param(name) == nil {
+				return fmt.Errorf("%s: unknown parameter %s in %q", what, name, cond)
+			}
+		}
+		return nil
+	}
+	for _, s := range wf.Stages {
+		if err := check("stage "+s.Name+" condition", s.Condition); err != nil {
+			return err
+		}
+	}
+	for _, l := range wf.Loops {
+		if err := check("loop "+l.Name+" until", l.Until); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// truthy reports whether a verdict value is set: non-empty, non-zero and
+// not false.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// verdictLineRe matches a line that is only a review verdict, such as
+// "Status: APPROVED" or "**NEEDS_CHANGES**", so prose like "not APPROVED"
+// doesn't count.
+var verdictLineRe = regexp.MustCompile(`(?im)^[\s*#>_-]*(?:(?:status|verdict|result)[\s*_]*:[\s*_]*)?(APPROVED|NEEDS_CHANGES)[\s*_.!]*$`)
+
+// isApproved reports whether a review from a stage without a schema
+// approves: its structured status if it has one, else its last verdict
+// line.
+func isApproved(review string) bool {
+	if v, err := extractVerdict(review); err == nil {
+		if status, ok := v["status"].(string); ok {
+			return strings.EqualFold(status, "APPROVED")
+		}
+	}
+	m := verdictLineRe.FindAllStringSubmatch(stripANSI(review), -1)
+	return len(m) > 0 && strings.EqualFold(m[len(m)-1][1], "APPROVED")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		cond   string
+		stages []string
+		params []string
+		err    string
+	}{
+		{cond: "true"},
+		{cond: "approved:code-review and not skipped:security", stages: []string{"code-review", "security"}},
+		{cond: "(passed:test || done:lint) && param:strict", stages: []string{"test", "lint"}, params: []string{"strict"}},
+		{cond: "verdict:review.risk!=high", stages: []string{"review"}},
+		{cond: `contains:plan:"needs migration"`, stages: []string{"plan"}},
+		{cond: "param:mode=fast or env:CI", params: []string{"mode"}},
+		{cond: "!dirty"},
+		{cond: "", err: "empty condition"},
+		{cond: "and true", err: `unexpected "and"`},
+		{cond: "true and", err: "condition ends unexpectedly"},
+		{cond: "(true", err: "missing )"},
+		{cond: "true)", err: `unexpected ")"`},
+		{cond: "true false", err: `unexpected "false"`},
+		{cond: "bogus:x", err: `unknown condition "bogus:x"`},
+		{cond: "dirty:yes", err: "dirty takes no argument"},
+		{cond: "approved:", err: "missing argument"},
+		{cond: "verify:maybe", err: "expected verify:passed or verify:failed"},
+		{cond: "verdict:review", err: "expected verdict:<stage>.<field>"},
+		{cond: "contains:plan", err: "expected contains:<stage>:<text>"},
+		{cond: `contains:plan:"open`, err: "unterminated quote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cond, func(t *testing.T) {
+			c, err := parseCondition(tt.cond)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.stages, tt.stages) {
+				t.Errorf("stages = %q, want %q", c.stages, tt.stages)
+			}
+			if !reflect.DeepEqual(c.params, tt.params) {
+				t.Errorf("params = %q, want %q", c.params, tt.params)
+			}
+		})
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	t.Setenv("COND_TEST_SET", "Yes")
+	t.Setenv("COND_TEST_EMPTY", "")
+
+	ctx := &WorkflowContext{
+		Workflow: &Workflow{Stages: []Stage{
+			{Name: "plan"},
+			{Name: "review", Schema: &OutputSchema{Fields: map[string]SchemaField{
+				"status": {Enum: []string{"APPROVED", "NEEDS_CHANGES"}, Required: true},
+				"risk":   {Enum: []string{"low", "high"}},
+				"issues": {Type: "array"},
+			}}},
+			{Name: "legacy"},
+			{Name: "security"},
+		}},
+		Results: map[string]string{
+			"plan":   "needs a database migration",
+			"review": "```json\n{\"status\": \"APPROVED\", \"risk\": \"LOW\", \"issues\": []}\n```",
+			"legacy": "Not APPROVED, see above",
+			"verify": "## Summary\nALL PASSED",
+		},
+		Params:  map[string]string{"mode": "fast", "strict": "false"},
+		Skipped: []string{"security"},
+	}
+	tests := []struct {
+		cond string
+		want bool
+	}{
+		{"true", true},
+		{"not true", false},
+		{"true or false and false", true}, // and binds tighter
+		{"(true or false) and false", false},
+		{"not false and not false", true},
+		{"! true || true", true},
+		{"done:plan", true},
+		{"done:review and done:security", false},
+		{"skipped:security", true},
+		{"skipped:plan", false},
+		{`contains:plan:"database migration"`, true},
+		{"contains:plan:rollback", false},
+		{"approved:review", true},
+		{"approved:legacy", false},
+		{"approved:plan", false},
+		{"verify:passed", true},
+		{"verify:failed", false},
+		{"passed:verify", true},
+		{"verdict:review.status=approved", true},
+		{"verdict:review.risk=low", true},
+		{"verdict:review.risk!=high", true},
+		{"verdict:review.issues", false}, // Empty list
+		{"verdict:review.missing", false},
+		{"verdict:plan.status", false}, // No schema
+		{"param:mode=FAST", true},
+		{"param:mode!=fast", false},
+		{"param:strict", false},
+		{"param:other", false},
+		{"env:COND_TEST_SET=yes", true},
+		{"env:COND_TEST_EMPTY", false},
+		{"env:COND_TEST_UNSET!=x", true},
+		{"bogus:x", false}, // Doesn't parse, so doesn't hold
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cond, func(t *testing.T) {
+			if got := checkCondition(tt.cond, ctx); got != tt.want {
+				t.Errorf("checkCondition(%q) = %v, want %v", tt.cond, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckConditions(t *testing.T) {
+	tests := []struct {
+		name string
+		wf   Workflow
+		err  string
+	}{
+		{
+			name: "known stages and params",
+			wf: Workflow{
+				Params: []Param{{Name: "mode"}},
+				Stages: []Stage{{Name: "a"}, {Name: "b", Condition: "done:a and param:mode=x and verify:passed"}},
+			},
+		},
+		{
+			name: "sub-workflow result",
+			wf:   Workflow{Stages: []Stage{{Name: "sub", Workflow: "other"}, {Name: "b", Condition: "done:sub/inner"}}},
+		},
+		{
+			name: "unknown stage",
+			wf:   Workflow{Stages: []Stage{{Name: "a", Condition: "done:nope"}}},
+			err:  "stage a condition: unknown stage nope",
+		},
+		{
+			name: "unknown param",
+			wf:   Workflow{Stages: []Stage{{Name: "a", Condition: "param:nope"}}},
+			err:  "stage a condition: unknown parameter nope",
+		},
+		{
+			name: "bad loop test",
+			wf:   Workflow{Stages: []Stage{{Name: "a"}}, Loops: []Loop{{Name: "l", Body: []string{"a"}, Until: "approved:a and"}}},
+			err:  "loop l until: condition ends unexpectedly",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.wf.checkConditions()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
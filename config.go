@@ -1,21 +1,28 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
 )
 
 type BackendConfig struct {
-	Name       string   `json:"name"`
-	Cmd        string   `json:"cmd"`
-	Args       []string `json:"args"`
-	PromptFlag string   `json:"promptFlag"`
-	ResumeFlag string   `json:"resumeFlag"`
-	ModelFlag  string   `json:"modelFlag,omitempty"` // e.g., "--model" for claude/kiro
+	Name            string   `json:"name"`
+	Cmd             string   `json:"cmd"`
+	Args            []string `json:"args"`
+	PromptFlag      string   `json:"promptFlag"`
+	ResumeFlag      string   `json:"resumeFlag"`
+	ModelFlag       string   `json:"modelFlag,omitempty"`       // e.g., "--model" for claude/kiro
+	CostPer1kTokens float64  `json:"costPer1kTokens,omitempty"` // For budgets; tokens are estimated
 }
 
 type Config struct {
+	Version   int                      `json:"version"`
 	Default   string                   `json:"default"`
 	Backends  map[string]BackendConfig `json:"backends"`
 	Workflows map[string]Workflow      `json:"workflows,omitempty"`
@@ -31,22 +38,218 @@ func init() {
 func loadConfig() *Config {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return defaultConfig()
+		return recordBackendOrigins(defaultConfig(), builtinSource)
+	}
+	migrated, applied, err := migrateConfigData(data, globalMigrations, configVersion)
+	if err != nil {
+		fmt.Printf("%s %s: %v\n", yellow("!"), configPath, err)
+	} else if len(applied) > 0 {
+		fmt.Printf("%s %s uses an older format, run `proxy config migrate` to update it\n", dim("●"), configPath)
 	}
 	var cfg Config
-	if json.Unmarshal(data, &cfg) != nil {
-		return defaultConfig()
+	if json.Unmarshal(migrated, &cfg) != nil {
+		return recordBackendOrigins(defaultConfig(), builtinSource)
+	}
+	return recordBackendOrigins(&cfg, configPath)
+}
+
+func recordBackendOrigins(cfg *Config, source string) *Config {
+	builtin := defaultConfig().Backends
+	names := make([]string, 0, len(cfg.Backends))
+	for name := range cfg.Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		def, ok := builtin[name]
+		if ok && source != builtinSource {
+			backendOrigins.set(name, builtinSource, "")
+		}
+		o := backendOrigins.set(name, source, "")
+		// Config files carry copies of the built-in backends; only a
+		// changed one is worth a warning
+		if ok && !reflect.DeepEqual(def, cfg.Backends[name]) {
+			warnShadow("backend", name, o)
+		}
+	}
+	return cfg
+}
+
+// configDoc is a config file kept as raw top-level fields, in file order, so
+// that keys we don't know about survive a rewrite untouched.
+type configDoc struct {
+	fields []configField
+}
+
+type configField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+func parseConfigDoc(data []byte) (*configDoc, error) {
+	doc := &configDoc{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return doc, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("config is not a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		doc.fields = append(doc.fields, configField{Key: key, Value: raw})
+	}
+	return doc, nil
+}
+
+func (d *configDoc) Get(key string) (json.RawMessage, bool) {
+	for _, f := range d.fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set replaces the value of key in place, or appends it if missing.
+func (d *configDoc) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	for i := range d.fields {
+		if d.fields[i].Key == key {
+			d.fields[i].Value = raw
+			return nil
+		}
 	}
-	return &cfg
+	d.fields = append(d.fields, configField{Key: key, Value: raw})
+	return nil
 }
 
-func saveConfig(cfg *Config) error {
-	data, _ := json.MarshalIndent(cfg, "", "  ")
-	return os.WriteFile(configPath, data, 0644)
+func (d *configDoc) Delete(key string) {
+	for i, f := range d.fields {
+		if f.Key == key {
+			d.fields = append(d.fields[:i], d.fields[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *configDoc) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, f := range d.fields {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(f.Key)
+		buf.WriteString("\n  ")
+		buf.Write(key)
+		buf.WriteString(": ")
+		var value bytes.Buffer
+		if json.Indent(&value, f.Value, "  ", "  ") != nil {
+			value.Write(f.Value)
+		}
+		buf.Write(value.Bytes())
+	}
+	buf.WriteString("\n}\n")
+	return buf.Bytes()
+}
+
+// updateConfigFile sets the given top-level keys in the config file. Other
+// keys keep their value and position; new keys are appended at the end in
+// sorted order.
+func updateConfigFile(changes map[string]interface{}) error {
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return editConfigFile(configPath, func(doc *configDoc) error {
+		for _, key := range keys {
+			if err := doc.Set(key, changes[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// editConfigFile rewrites a JSON config file under lock, keeping a .bak copy
+// of the previous contents. A missing global config starts out as the
+// built-in defaults, so saving one key doesn't drop every backend.
+func editConfigFile(path string, edit func(doc *configDoc) error) error {
+	unlock, err := lockConfig(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	old, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	base := old
+	if len(base) == 0 && path == configPath {
+		if base, err = json.Marshal(defaultConfig()); err != nil {
+			return err
+		}
+	}
+	doc, err := parseConfigDoc(base)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := edit(doc); err != nil {
+		return err
+	}
+
+	if len(old) > 0 {
+		if err := writeFileAtomic(path+".bak", old, 0644); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, doc.Bytes(), 0644)
+}
+
+// lockConfig takes an exclusive lock on a config file so that two
+// terminals saving at the same time don't interleave their writes.
+func lockConfig(path string) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		// A lock older than this was left behind by a crashed process
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > 30*time.Second {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("config is locked by another process (%s)", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 }
 
 func defaultConfig() *Config {
 	return &Config{
+		Version: configVersion,
 		Default: "claude",
 		Backends: map[string]BackendConfig{
 			"claude": {
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Node states recorded in the checkpoint for DAG workflows.
+const (
+	nodePending = "pending"
+	nodeRunning = "running"
+	nodeDone    = "done"
+	nodeSkipped = "skipped"
+	nodeFailed  = "failed"
+)
+
+// isDAG reports whether any stage declares dependencies. Such workflows are
+// scheduled by dependency instead of walked in order.
+func (wf *Workflow) isDAG() bool {
+	for _, s := range wf.Stages {
+		if len(s.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dagOrder checks stage dependencies and returns stage indexes in
+// topological order, keeping declaration order between independent stages.
+func (wf *Workflow) dagOrder() ([]int, error) {
+	n := len(wf.Stages)
+	index := make(map[string]int, n)
+	for i, s := range wf.Stages {
+		if _, dup := index[s.Name]; dup {
+			return nil, fmt.Errorf("duplicate stage name %s", s.Name)
+		}
+		index[s.Name] = i
+	}
+
+	indeg := make([]int, n)
+	dependents := make([][]int, n)
+	for i, s := range wf.Stages {
+		for _, dep := range s.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("stage %s depends on unknown stage %s", s.Name, dep)
+			}
+			indeg[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	order := make([]int, 0, n)
+	taken := make([]bool, n)
+	for len(order) < n {
+		next := -1
+		for i := 0; i < n; i++ {
+			if !taken[i] && indeg[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cycle []string
+			for i := 0; i < n; i++ {
+				if !taken[i] {
+					cycle = append(cycle, wf.Stages[i].Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between stages: %s", strings.Join(cycle, ", "))
+		}
+		taken[next] = true
+		order = append(order, next)
+		for _, d := range dependents[next] {
+			indeg[d]--
+		}
+	}
+	return order, nil
+}
+
+func (wf *Workflow) nodeReady(ctx *WorkflowContext, k int) bool {
+	if ctx.Nodes[wf.Stages[k].Name] != nodePending {
+		return false
+	}
+	for _, dep := range wf.Stages[k].DependsOn {
+		if st := ctx.Nodes[dep]; st != nodeDone && st != nodeSkipped {
+			return false
+		}
+	}
+	return true
+}
+
+// executeDAG runs a workflow whose stages declare dependsOn. A stage starts
+// as soon as everything it depends on is done or skipped, so independent
+// stages overlap. Node status is checkpointed after every change; on resume
+// only nodes that aren't done or skipped run again.
+func (wf *Workflow) executeDAG(ctx *WorkflowContext) error {
+	order, err := wf.dagOrder()
+	if err != nil {
+		return fmt.Errorf("workflow %s: %w", wf.Key, err)
+	}
+	if ctx.Nodes == nil {
+		ctx.Nodes = make(map[string]string)
+	}
+	for _, s := range wf.Stages {
+		if st := ctx.Nodes[s.Name]; st != nodeDone && st != nodeSkipped {
+			ctx.Nodes[s.Name] = nodePending
+		}
+	}
+
+	total := len(wf.Stages)
+	finished := func() int {
+		n := 0
+		for _, st := range ctx.Nodes {
+			if st == nodeDone || st == nodeSkipped {
+				n++
+			}
+		}
+		return n
+	}
+
+	done := make(chan *ParallelResult)
+	running := 0
+	var failed []string
+	var stopErr error
+
+	for {
+		// Start everything that is ready. Skipping a stage can make others
+		// ready, so repeat until nothing changes.
+		var launch, interactive []int
+		for changed := len(failed) == 0; changed; {
+			changed = false
+			for _, k := range order {
+				s := wf.Stages[k]
+				if !wf.nodeReady(ctx, k) {
+					continue
+				}
+				if s.Condition != "" && !checkCondition(s.Condition, ctx) {
+					fmt.Printf("%s [%d/%d] %s - %s\n", dim("○"), finished()+1, total, s.Name, dim("skipped (condition not met)"))
+					ctx.Nodes[s.Name] = nodeSkipped
+					ctx.skip(s.Name)
+					changed = true
+					continue
+				}
+				if s.Skippable {
+					if askSkip(fmt.Sprintf("%s Skip stage %s? [y/N]: ", yellow("?"), s.Name)) {
+						fmt.Printf("%s Skipped\n", dim("○"))
+						ctx.Nodes[s.Name] = nodeSkipped
+						ctx.skip(s.Name)
+						changed = true
+						continue
+					}
+				}
+				ctx.Nodes[s.Name] = nodeRunning
+				if s.Interactive {
+					interactive = append(interactive, k)
+				} else {
+					launch = append(launch, k)
+				}
+			}
+		}
+
+		// A stage running on its own streams to the terminal as usual;
+		// overlapping stages write to their own log files.
+		toFile := running > 0 || len(launch) > 1
+		for _, k := range launch {
+			s := wf.Stages[k]
+			fmt.Printf("%s [%d/%d] %s (%s)\n", cyan("●"), finished()+1, total, s.Name, s.runner())
+			running++
+			go func(branch *WorkflowContext, idx int, s Stage) {
+				done <- runBranch(branch, idx, s, toFile)
+			}(branchContext(ctx), k, s)
+		}
+		saveCheckpoint(ctx, wf, 0, statusRunning)
+
+		// Interactive stages need the terminal to themselves
+		if running == 0 && len(interactive) > 0 {
+			k := interactive[0]
+			for _, other := range interactive[1:] {
+				ctx.Nodes[wf.Stages[other].Name] = nodePending
+			}
+			s := wf.Stages[k]
+			fmt.Printf("%s [%d/%d] %s (%s)\n", cyan("●"), finished()+1, total, s.Name, s.runner())
+			r := runBranch(branchContext(ctx), k, s, false)
+			failed = wf.finishNode(ctx, r, failed)
+			if errors.Is(r.Err, errStopped) {
+				stopErr = r.Err
+			}
+			continue
+		}
+		for _, k := range interactive {
+			ctx.Nodes[wf.Stages[k].Name] = nodePending
+		}
+
+		if running == 0 {
+			break
+		}
+		r := <-done
+		running--
+		failed = wf.finishNode(ctx, r, failed)
+		if errors.Is(r.Err, errStopped) {
+			stopErr = r.Err
+		}
+	}
+
+	if stopErr != nil {
+		return wf.stop(ctx, 0, stopErr)
+	}
+	if len(failed) > 0 {
+		saveCheckpoint(ctx, wf, 0, statusFailed)
+		return fmt.Errorf("stage %s failed", strings.Join(failed, ", "))
+	}
+	var blocked []string
+	for _, k := range order {
+		if st := ctx.Nodes[wf.Stages[k].Name]; st != nodeDone && st != nodeSkipped {
+			blocked = append(blocked, wf.Stages[k].Name)
+		}
+	}
+	if len(blocked) > 0 {
+		saveCheckpoint(ctx, wf, 0, statusStopped)
+		return fmt.Errorf("stages never became ready: %s", strings.Join(blocked, ", "))
+	}
+
+	saveCheckpoint(ctx, wf, len(wf.Stages), statusCompleted)
+	wf.printCompleted(ctx)
+	return nil
+}
+
+func (wf *Workflow) finishNode(ctx *WorkflowContext, r *ParallelResult, failed []string) []string {
+	ctx.Timer.StageComplete()
+	if !mergeBranch(ctx, wf, r, "depends on "+strings.Join(wf.Stages[r.Index].DependsOn, ", ")) {
+		fmt.Printf("%s %s failed: %v\n", red("✗"), r.Name, r.Err)
+		ctx.Nodes[r.Name] = nodeFailed
+		failed = append(failed, r.Name)
+	} else {
+		fmt.Printf("%s %s completed (%s)\n\n", green("✓"), r.Name, formatDuration(r.Duration))
+		ctx.Nodes[r.Name] = nodeDone
+	}
+	saveCheckpoint(ctx, wf, 0, statusRunning)
+	return failed
+}
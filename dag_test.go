@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDagOrder(t *testing.T) {
+	type st struct {
+		name string
+		deps []string
+	}
+	tests := []struct {
+		name   string
+		stages []st
+		want   string // Stage names in order
+		err    string
+	}{
+		{
+			name:   "no dependencies keeps declaration order",
+			stages: []st{{"a", nil}, {"b", nil}, {"c", nil}},
+			want:   "a,b,c",
+		},
+		{
+			name:   "dependency declared later",
+			stages: []st{{"b", []string{"a"}}, {"a", nil}},
+			want:   "a,b",
+		},
+		{
+			name:   "diamond",
+			stages: []st{{"a", nil}, {"b", []string{"a"}}, {"c", []string{"a"}}, {"d", []string{"c", "b"}}},
+			want:   "a,b,c,d",
+		},
+		{
+			name:   "independent stage stays in place",
+			stages: []st{{"x", []string{"a"}}, {"y", nil}, {"a", nil}},
+			want:   "y,a,x",
+		},
+		{
+			name:   "cycle",
+			stages: []st{{"a", []string{"c"}}, {"b", []string{"a"}}, {"c", []string{"b"}}, {"d", nil}},
+			err:    "dependency cycle between stages: a, b, c",
+		},
+		{
+			name:   "self dependency",
+			stages: []st{{"a", []string{"a"}}},
+			err:    "dependency cycle between stages: a",
+		},
+		{
+			name:   "unknown dependency",
+			stages: []st{{"a", []string{"nope"}}},
+			err:    "stage a depends on unknown stage nope",
+		},
+		{
+			name:   "duplicate name",
+			stages: []st{{"a", nil}, {"a", nil}},
+			err:    "duplicate stage name a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := &Workflow{}
+			for _, s := range tt.stages {
+				wf.Stages = append(wf.Stages, Stage{Name: s.name, DependsOn: s.deps})
+			}
+			order, err := wf.dagOrder()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make([]string, len(order))
+			for i, k := range order {
+				names[i] = wf.Stages[k].Name
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("order = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -87,3 +87,81 @@ func (before *FileSnapshot) Diff(after *FileSnapshot) string {
 
 	return diff.String()
 }
+
+// maxDiffCells caps the LCS table of lineDiff (about 32 MB). Past it, the
+// changed middle is shown as removed and re-added instead of diffed.
+const maxDiffCells = 4 << 20
+
+// lineDiff returns a minimal line-based diff of a and b, with unchanged lines
+// prefixed by two spaces and changes by "- " / "+ ".
+func lineDiff(a, b string) string {
+	al := strings.Split(strings.TrimRight(a, "\n"), "\n")
+	bl := strings.Split(strings.TrimRight(b, "\n"), "\n")
+
+	// Common leading and trailing lines need no table
+	pre := 0
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+
+	var out strings.Builder
+	for _, line := range al[:pre] {
+		out.WriteString("  " + line + "\n")
+	}
+	am, bm := al[pre:len(al)-suf], bl[pre:len(bl)-suf]
+	if (len(am)+1)*(len(bm)+1) > maxDiffCells {
+		for _, line := range am {
+			out.WriteString("- " + line + "\n")
+		}
+		for _, line := range bm {
+			out.WriteString("+ " + line + "\n")
+		}
+	} else {
+		diffLCS(&out, am, bm)
+	}
+	for _, line := range al[len(al)-suf:] {
+		out.WriteString("  " + line + "\n")
+	}
+	return out.String()
+}
+
+// diffLCS writes the diff of al and bl using a longest common subsequence
+// table.
+func diffLCS(out *strings.Builder, al, bl []string) {
+	// lcs[i][j] is the length of the longest common subsequence of al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			out.WriteString("  " + al[i] + "\n")
+			i++
+			j++
+		case i < len(al) && (j == len(bl) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + al[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + bl[j] + "\n")
+			j++
+		}
+	}
+}
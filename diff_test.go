@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb", "  a\n  b\n"},
+		{"added", "a\nc", "a\nb\nc", "  a\n+ b\n  c\n"},
+		{"removed", "a\nb\nc", "a\nc", "  a\n- b\n  c\n"},
+		{"changed", "a\nb\nc", "a\nx\nc", "  a\n- b\n+ x\n  c\n"},
+		{"empty to text", "", "a", "- \n+ a\n"},
+		{"interleaved", "a\nb\nc\nd", "b\nx\nd\ne", "- a\n  b\n- c\n+ x\n  d\n+ e\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineDiff(tt.a, tt.b); got != tt.want {
+				t.Errorf("lineDiff(%q, %q) =\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// Past maxDiffCells the changed middle is replaced wholesale instead of
+// building a huge table.
+func TestLineDiffLarge(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 5000; i++ {
+		a = append(a, "a"+strings.Repeat("x", i%7))
+		b = append(b, "b"+strings.Repeat("y", i%5))
+	}
+	a = append([]string{"head"}, append(a, "tail")...)
+	b = append([]string{"head"}, append(b, "tail")...)
+
+	got := strings.Split(strings.TrimRight(lineDiff(strings.Join(a, "\n"), strings.Join(b, "\n")), "\n"), "\n")
+	if len(got) != 10002 {
+		t.Fatalf("got %d lines, want 10002", len(got))
+	}
+	if got[0] != "  head" || got[1] != "- "+a[1] || got[5001] != "+ "+b[1] || got[10001] != "  tail" {
+		t.Errorf("unexpected layout: %q %q %q %q", got[0], got[1], got[5001], got[10001])
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Lineage records where a forked run came from.
+type Lineage struct {
+	Parent string    `json:"parent"` // Run directory the fork was copied from
+	Stage  string    `json:"stage"`  // Fork point: the first stage that ran again
+	At     time.Time `json:"at"`
+}
+
+// forkRun copies a run, with the results of everything before stage
+// opts.From, into a new run directory and continues there with the
+// overrides of opts. The original run is left as it is.
+func forkRun(folder string, opts resumeOptions) error {
+	srcDir, err := findRunDir(folder)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(srcDir); err == nil {
+		srcDir = resolved
+	}
+	state, err := loadCheckpoint(srcDir)
+	if err != nil {
+		return fmt.Errorf("cannot load checkpoint: %w", err)
+	}
+	wf := state.Definition
+	if wf == nil {
+		wf = getWorkflow(state.WorkflowName)
+	}
+	if wf == nil {
+		return fmt.Errorf("unknown workflow: %s", state.WorkflowName)
+	}
+	idx, err := wf.stageIndex(opts.From)
+	if err != nil {
+		return err
+	}
+
+	// latest moves to the fork only once the copy is complete
+	workDir := makeRunDir()
+	if err := copyRunFiles(srcDir, workDir); err != nil {
+		os.RemoveAll(workDir)
+		return err
+	}
+	logFile, _ := os.Create(filepath.Join(workDir, "log.md"))
+	defer logFile.Close()
+
+	parent := filepath.Base(srcDir)
+	ctx := restoreContext(state, logFile)
+	ctx.WorkDir = workDir
+	ctx.Workflow = wf
+	ctx.Meter = newMeter(wf, nil)
+	ctx.StartedAt = time.Now()
+	ctx.Revisions = nil
+	ctx.Lineage = &Lineage{Parent: parent, Stage: opts.From, At: time.Now()}
+	for name, path := range ctx.Outputs {
+		ctx.Outputs[name] = filepath.Join(workDir, filepath.Base(path))
+	}
+
+	if _, err := wf.override(ctx, idx, opts, ""); err != nil {
+		return err
+	}
+	cleared := wf.clearFrom(ctx, idx, "")
+	ctx.Manifest = openManifest(workDir)
+	kept := ctx.Gates[:0]
+	for _, g := range ctx.Gates {
+		if !contains(cleared, g.Stage) {
+			kept = append(kept, g)
+		}
+	}
+	ctx.Gates = kept
+
+	ctx.log("# Workflow: %s\n", wf.Name)
+	ctx.log("**Requirement:** %s\n", ctx.Requirement)
+	ctx.log("**Forked from:** %s at %s\n", parent, opts.From)
+	if len(ctx.Params) > 0 {
+		ctx.log("**Params:** %s\n", formatParams(ctx.Params))
+	}
+	ctx.log("**Time:** %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	fmt.Printf("\n%s Fork of %s at %s: %s\n", cyan("⑂"), parent, opts.From, wf.Name)
+	fmt.Printf("%s Requirement: %s\n", dim("│"), ctx.Requirement)
+	fmt.Printf("%s Kept: %d stage result(s), running again: %s\n", dim("│"), len(wf.Stages)-len(cleared), strings.Join(cleared, ", "))
+	fmt.Printf("%s Directory: %s\n\n", dim("│"), workDir)
+
+	if _, ok := config.Backends[ctx.Backend]; ok && ctx.Backend != current {
+		oldBackend := current
+		current = ctx.Backend
+		defer func() { current = oldBackend }()
+	}
+
+	next := idx
+	if wf.isDAG() {
+		next = 0
+	}
+	saveCheckpoint(ctx, wf, next, statusRunning)
+	pointLatest(workDir)
+	return wf.execute(ctx, next)
+}
+
+// copyRunFiles copies a run directory's artifacts, but not its checkpoint,
+// log, summary, manifest, report or revisions, which describe the original
+// run.
+func copyRunFiles(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		switch rel {
+		case ".":
+			return nil
+		case "state.json", "log.md", "summary.json", "manifest.json", "report.md", "report.html":
+			return nil
+		case "revisions":
+			return filepath.SkipDir
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
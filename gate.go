@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GateDecision records what the user decided at a stage's approval gate.
+type GateDecision struct {
+	Stage    string    `json:"stage"`
+	Decision string    `json:"decision"` // "approved", "rejected" or "edited"
+	Feedback string    `json:"feedback,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// gateStage pauses after a stage with gate set until the user approves its
+// output. Rejecting re-runs the stage with the user's feedback; editing
+// opens the output in $EDITOR and uses the edited text from then on. Must
+// be called from the goroutine that owns ctx.
+func gateStage(ctx *WorkflowContext, idx int, stage *Stage) error {
+	if runOpts.NonInteractive {
+		if !runOpts.Yes {
+			ctx.recordGate(stage.Name, "rejected", "non-interactive run without --yes")
+			return fmt.Errorf("gate on %s needs approval (use --yes to approve gates)", stage.Name)
+		}
+		ctx.recordGate(stage.Name, "approved", "")
+		fmt.Printf("%s Approved %s\n\n", green("✓"), dim("(--yes)"))
+		return nil
+	}
+	for {
+		showGateOutput(ctx, stage)
+		fmt.Printf("%s [A]pprove, [r]eject with feedback, [e]dit %s: ", yellow("?"), stage.Name)
+		switch strings.ToLower(strings.TrimSpace(readLine())) {
+		case "a", "":
+			ctx.recordGate(stage.Name, "approved", "")
+			fmt.Printf("%s Approved\n\n", green("✓"))
+			return nil
+
+		case "e":
+			edited, err := editOutput(ctx, idx, stage)
+			if err != nil {
+				fmt.Printf("%s %v\n", red("✗"), err)
+				continue
+			}
+			if edited {
+				ctx.recordGate(stage.Name, "edited", "")
+				fmt.Printf("%s Saved edits\n", green("✓"))
+			} else {
+				fmt.Printf("%s No changes\n", dim("○"))
+			}
+
+		case "r":
+			fmt.Printf("%s Feedback: ", yellow("?"))
+			feedback := strings.TrimSpace(readLine())
+			ctx.recordGate(stage.Name, "rejected", feedback)
+			fmt.Printf("%s Re-running %s\n", cyan("↻"), stage.Name)
+
+			// A copy, so branches still running never see the feedback
+			rerun := *ctx
+			rerun.Feedback = fmt.Sprintf("## Previous Attempt\n%s\n\n## Feedback\nThe previous attempt was rejected. Address this feedback:\n%s\n",
+				truncate(stripANSI(ctx.Results[stage.Name]), 4000), feedback)
+			rerun.Output = nil
+			result, extra, err := execStage(stage, &rerun)
+			if err != nil {
+				return err
+			}
+			for k, v := range extra {
+				ctx.Results[k] = v
+			}
+			ctx.Results[stage.Name] = result
+			saveStageOutput(ctx, idx, stage, result)
+			ctx.log("### Output (after feedback)\n```\n%s\n```\n\n", truncate(result, 2000))
+		}
+	}
+}
+
+// showGateOutput prints what the user is approving: the diff of the
+// project for stages that change code, otherwise the stage output.
+func showGateOutput(ctx *WorkflowContext, stage *Stage) {
+	text := stripANSI(ctx.Results[stage.Name])
+	title := "Output"
+	if (stage.Interactive || stage.Type == "shell") && ctx.BeforeSnapshot != nil {
+		text = ctx.BeforeSnapshot.Diff(takeSnapshot())
+		title = "Changes so far"
+	}
+	fmt.Printf("%s %s of %s:\n", cyan("▶"), title, stage.Name)
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > 40 {
+		lines = append(lines[:40], dim(fmt.Sprintf("... %d more lines ([e]dit to see all)", len(lines)-40)))
+	}
+	for _, l := range lines {
+		fmt.Printf("%s %s\n", dim("│"), l)
+	}
+}
+
+// editOutput opens the stage's output file (or a copy of its result if it
+// has none) in the user's editor and stores the edited text as the stage
+// result. It reports whether anything changed.
+func editOutput(ctx *WorkflowContext, idx int, stage *Stage) (bool, error) {
+	path := ctx.Outputs[stage.Name]
+	if path == "" {
+		path = filepath.Join(ctx.WorkDir, fmt.Sprintf("%d.%s.edit.md", idx, stage.Name))
+		if err := os.WriteFile(path, []byte(stripANSI(ctx.Results[stage.Name])), 0644); err != nil {
+			return false, err
+		}
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if err := openEditor(path); err != nil {
+		return false, err
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if string(after) == string(before) {
+		return false, nil
+	}
+	ctx.Results[stage.Name] = string(after)
+	ctx.log("### Edited by user\n```\n%s\n```\n\n", truncate(string(after), 2000))
+	return true, nil
+}
+
+// openEditor edits a file in $VISUAL, $EDITOR or vi and waits for it.
+func openEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$0"`, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor: %w", err)
+	}
+	return nil
+}
+
+func (ctx *WorkflowContext) recordGate(stage, decision, feedback string) {
+	ctx.Gates = append(ctx.Gates, GateDecision{Stage: stage, Decision: decision, Feedback: feedback, At: time.Now()})
+	if feedback != "" {
+		ctx.log("### Gate: %s\n%s\n\n", decision, feedback)
+	} else {
+		ctx.log("### Gate: %s\n\n", decision)
+	}
+}
+
+// withFeedback appends the feedback from a rejected gate, if any, to a
+// rendered prompt.
+func (ctx *WorkflowContext) withFeedback(prompt string) string {
+	if ctx == nil || ctx.Feedback == "" {
+		return prompt
+	}
+	return prompt + "\n\n" + ctx.Feedback
+}
+
+// stdinClosed is set once readLine hits end of input, so callers that
+// repeat a question can give up.
+var stdinClosed bool
+
+// readLine reads one line from stdin without buffering past it, so later
+// prompts still see their input. The terminal may still be in raw mode
+// after the line editor, where Enter sends '\r'.
+func readLine() string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 0 || err != nil {
+			stdinClosed = true
+			return string(line)
+		}
+		if b[0] == '\n' || b[0] == '\r' {
+			return string(line)
+		}
+		line = append(line, b[0])
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runInfo is one run directory as the history commands see it.
+type runInfo struct {
+	Name     string // Directory name under .workflow
+	Dir      string
+	State    *WorkflowState // nil if state.json can't be read
+	Manifest *Manifest      // nil for runs from before manifests
+	Started  time.Time
+}
+
+func (r *runInfo) status() string {
+	if r.State == nil {
+		return "unknown"
+	}
+	if r.State.Status == "" {
+		return statusRunning
+	}
+	return r.State.Status
+}
+
+// historyFilter selects runs for /workflow history.
+type historyFilter struct {
+	Workflow string
+	Status   string
+	Since    time.Time
+	Until    time.Time
+	Text     string
+	Limit    int // 0 for no limit
+}
+
+func (f *historyFilter) match(r *runInfo) bool {
+	if f.Workflow != "" && (r.State == nil || r.State.WorkflowName != f.Workflow) {
+		return false
+	}
+	if f.Status != "" && r.status() != f.Status {
+		return false
+	}
+	if !f.Since.IsZero() && r.Started.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Started.Before(f.Until) {
+		return false
+	}
+	if f.Text != "" {
+		if r.State == nil {
+			return false
+		}
+		text := strings.ToLower(f.Text)
+		if !strings.Contains(strings.ToLower(r.State.Requirement), text) && !resultsContain(r.State.Results, text) {
+			return false
+		}
+	}
+	return true
+}
+
+func resultsContain(results map[string]string, text string) bool {
+	for _, v := range results {
+		if strings.Contains(strings.ToLower(v), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// listRuns loads every run under .workflow, newest first. Runs that crashed
+// before writing state.json are listed too, with a nil State, so prune can
+// clean them up.
+func listRuns() []*runInfo {
+	baseDir := ".workflow"
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil
+	}
+	var runs []*runInfo
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "latest" {
+			continue
+		}
+		dir := filepath.Join(baseDir, e.Name())
+		r := &runInfo{Name: e.Name(), Dir: dir}
+		r.State, _ = loadCheckpoint(dir)
+		r.Manifest, _ = loadManifest(dir)
+		if r.State != nil && !r.State.StartedAt.IsZero() {
+			r.Started = r.State.StartedAt
+		} else if len(e.Name()) >= 15 {
+			// Directory names start with the time the run started
+			r.Started, _ = time.ParseInLocation("20060102_150405", e.Name()[:15], time.Local)
+		}
+		if r.Started.IsZero() {
+			if info, err := e.Info(); err == nil {
+				r.Started = info.ModTime()
+			}
+		}
+		runs = append(runs, r)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started.After(runs[j].Started) })
+	return runs
+}
+
+// historyCommand handles "/workflow history [show|compare|prune] ...".
+func historyCommand(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "show":
+			if len(args) != 2 {
+				fmt.Println("Usage: /workflow history show <run>")
+				return
+			}
+			if err := showRun(args[1]); err != nil {
+				fmt.Printf("%s %v\n", red("Error:"), err)
+			}
+			return
+		case "compare":
+			if len(args) < 3 || len(args) > 4 {
+				fmt.Println("Usage: /workflow history compare <runA> <runB> [stage]")
+				return
+			}
+			stage := ""
+			if len(args) == 4 {
+				stage = args[3]
+			}
+			if err := compareRuns(args[1], args[2], stage); err != nil {
+				fmt.Printf("%s %v\n", red("Error:"), err)
+			}
+			return
+		case "prune":
+			if err := pruneRuns(args[1:]); err != nil {
+				fmt.Printf("%s %v\n", red("Error:"), err)
+			}
+			return
+		}
+	}
+	f, err := parseHistoryArgs(args)
+	if err != nil {
+		fmt.Printf("%s %v\n", red("Error:"), err)
+		fmt.Println("Usage: /workflow history [--workflow name] [--status s] [--since date|age] [--until date|age] [--grep text] [--limit n]")
+		return
+	}
+	showWorkflowHistory(f)
+}
+
+func parseHistoryArgs(args []string) (historyFilter, error) {
+	f := historyFilter{Limit: 10}
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return f, fmt.Errorf("%s needs a value", args[i])
+		}
+		i++
+		v := args[i]
+		var err error
+		switch args[i-1] {
+		case "--workflow", "-w":
+			f.Workflow = v
+		case "--status", "-s":
+			switch v {
+			case statusRunning, statusCompleted, statusStopped, statusFailed:
+				f.Status = v
+			default:
+				err = fmt.Errorf("unknown status %s (running, completed, stopped or failed)", v)
+			}
+		case "--since":
+			f.Since, err = parseWhen(v)
+		case "--until":
+			f.Until, err = parseWhen(v)
+		case "--grep", "-g":
+			f.Text = v
+		case "--limit", "-n":
+			f.Limit, err = strconv.Atoi(v)
+			if err == nil && f.Limit < 0 {
+				err = fmt.Errorf("--limit must be 0 (no limit) or more")
+			}
+		default:
+			err = fmt.Errorf("unknown option %s", args[i-1])
+		}
+		if err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// parseWhen reads a date ("2025-12-16", "2025-12-16 15:04") or an age
+// back from now ("7d", "12h").
+func parseWhen(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	age, err := parseAge(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date or age %q (use 2006-01-02 or e.g. 7d, 12h)", s)
+	}
+	return time.Now().Add(-age), nil
+}
+
+// parseAge is time.ParseDuration plus days ("30d") and weeks ("2w").
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("bad age %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("bad age %q", s)
+	}
+	return d, nil
+}
+
+// showWorkflowHistory lists the runs matching f, newest first, with forks
+// under the run they came from.
+func showWorkflowHistory(f historyFilter) {
+	all := listRuns()
+	var runs []*runInfo
+	for _, r := range all {
+		if f.match(r) {
+			runs = append(runs, r)
+		}
+	}
+	if len(runs) == 0 {
+		fmt.Println(dim("No workflow history"))
+		return
+	}
+	more := 0
+	if f.Limit > 0 && len(runs) > f.Limit {
+		more = len(runs) - f.Limit
+		runs = runs[:f.Limit]
+	}
+
+	shown := make(map[string]bool)
+	for _, r := range runs {
+		shown[r.Name] = true
+	}
+	children := make(map[string][]*runInfo)
+	var roots []*runInfo
+	for _, r := range runs {
+		if r.State != nil && r.State.Lineage != nil && shown[r.State.Lineage.Parent] {
+			children[r.State.Lineage.Parent] = append(children[r.State.Lineage.Parent], r)
+			continue
+		}
+		roots = append(roots, r)
+	}
+
+	fmt.Println(cyan("Workflow History:"))
+	var show func(r *runInfo, indent string)
+	show = func(r *runInfo, indent string) {
+		fmt.Printf("%s%s\n", indent, r.line())
+		// Oldest fork first
+		kids := children[r.Name]
+		for i := len(kids) - 1; i >= 0; i-- {
+			show(kids[i], strings.Replace(indent, "└─ ", "   ", 1)+"  └─ ")
+		}
+	}
+	for _, r := range roots {
+		show(r, "  ")
+	}
+	if more > 0 {
+		fmt.Println(dim(fmt.Sprintf("  ... %d more (--limit 0 shows all)", more)))
+	}
+}
+
+// line is a run's one-line history entry.
+func (r *runInfo) line() string {
+	if r.State == nil {
+		if _, err := os.Stat(filepath.Join(r.Dir, "state.json")); os.IsNotExist(err) {
+			return dim(r.Name) + " " + red("(no state.json, the run never saved a checkpoint)")
+		}
+		return dim(r.Name) + " " + red("(unreadable state.json)")
+	}
+	st := r.State
+	progress := fmt.Sprintf("stage %d", st.CurrentStage+1)
+	if r.Manifest != nil {
+		progress = r.Manifest.summary()
+	}
+	fork := ""
+	if st.Lineage != nil {
+		fork = dim(fmt.Sprintf(" ⑂ %s at %s", st.Lineage.Parent, st.Lineage.Stage))
+	}
+	return fmt.Sprintf("%s %s %s - %s (%s)%s", dim(r.Name), green(st.WorkflowName), colorStatus(r.status()), shorten(st.Requirement, 50), progress, fork)
+}
+
+func colorStatus(s string) string {
+	switch s {
+	case statusCompleted:
+		return green(s)
+	case statusFailed:
+		return red(s)
+	default:
+		return yellow(s)
+	}
+}
+
+// shorten returns the first line of s, cut to n characters.
+func shorten(s string, n int) string {
+	s, _, cut := strings.Cut(strings.TrimSpace(s), "\n")
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n]) + "…"
+	}
+	if cut {
+		return s + "…"
+	}
+	return s
+}
+
+// showRun prints the details of one run.
+func showRun(folder string) error {
+	dir, err := findRunDir(folder)
+	if err != nil {
+		return err
+	}
+	r, err := loadReport(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s %s - %s\n", cyan("Run:"), r.Run, r.Name)
+	fmt.Printf("%s Workflow: %s\n", dim("│"), green(r.Workflow))
+	fmt.Printf("%s Requirement: %s\n", dim("│"), r.Requirement)
+	fmt.Printf("%s Status: %s\n", dim("│"), colorStatus(r.Status))
+	fmt.Printf("%s Started: %s, took %s\n", dim("│"), r.StartedAt.Format("2006-01-02 15:04:05"), r.Duration.Round(time.Second))
+	if len(r.Params) > 0 {
+		fmt.Printf("%s Params: %s\n", dim("│"), formatParams(r.Params))
+	}
+	if r.Lineage != nil {
+		fmt.Printf("%s Forked from %s at %s\n", dim("│"), r.Lineage.Parent, r.Lineage.Stage)
+	}
+	var forks []string
+	for _, other := range listRuns() {
+		if other.State != nil && other.State.Lineage != nil && other.State.Lineage.Parent == r.Run {
+			forks = append(forks, fmt.Sprintf("%s (at %s)", other.Name, other.State.Lineage.Stage))
+		}
+	}
+	if len(forks) > 0 {
+		fmt.Printf("%s Forks: %s\n", dim("│"), strings.Join(forks, ", "))
+	}
+	if r.Usage != nil {
+		fmt.Printf("%s Usage: %s\n", dim("│"), formatUsage(r.Usage.Total))
+	}
+
+	attempts := make(map[string]int)
+	last := make(map[string]reportEntry)
+	for _, e := range r.Timeline {
+		if e.Status != nodeSkipped {
+			attempts[e.Name]++
+		}
+		last[e.Name] = e
+	}
+	fmt.Printf("\n%s\n", cyan("Stages:"))
+	for _, s := range r.Stages {
+		detail := s.Runner
+		if e, ok := last[s.Name]; ok && e.Status != nodeSkipped {
+			detail = fmt.Sprintf("%s, %s", e.Runner, e.Duration.Round(time.Millisecond))
+		}
+		if n := attempts[s.Name]; n > 1 {
+			detail += fmt.Sprintf(", %d attempts", n)
+		}
+		if s.Verdict != "" {
+			detail += ", " + s.Verdict
+		}
+		fmt.Printf("  %s %-16s %s\n", stageMark(s.Status), s.Name, dim(detail))
+		if e, ok := last[s.Name]; ok && e.Error != "" {
+			fmt.Printf("    %s\n", red(shorten(e.Error, 100)))
+		}
+	}
+	for _, g := range r.Gates {
+		fmt.Printf("%s Gate %s: %s %s\n", dim("│"), g.Stage, g.Decision, dim(shorten(g.Feedback, 60)))
+	}
+	for _, rev := range r.Revisions {
+		fmt.Printf("%s Revision %d: restarted at %s, set aside %s\n", dim("│"), rev.N, rev.From, strings.Join(rev.Stages, ", "))
+	}
+	fmt.Printf("\n%s %s\n", dim("Directory:"), dir)
+	fmt.Printf("%s /workflow report %s\n", dim("Full report:"), r.Run)
+	return nil
+}
+
+func stageMark(status string) string {
+	switch status {
+	case nodeDone:
+		return green("✓")
+	case nodeFailed:
+		return red("✗")
+	case nodeSkipped:
+		return dim("○")
+	case statusStopped, statusRunning:
+		return yellow("■")
+	}
+	return dim("·")
+}
+
+// compareRuns prints how two runs differ, stage by stage: status, runner
+// and a line diff of their outputs. With stage set only that stage is
+// compared.
+func compareRuns(a, b, stage string) error {
+	dirA, err := findRunDir(a)
+	if err != nil {
+		return err
+	}
+	dirB, err := findRunDir(b)
+	if err != nil {
+		return err
+	}
+	ra, err := loadReport(dirA)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a, err)
+	}
+	rb, err := loadReport(dirB)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b, err)
+	}
+
+	stagesA := make(map[string]reportStage)
+	for _, s := range ra.Stages {
+		stagesA[s.Name] = s
+	}
+	stagesB := make(map[string]reportStage)
+	var names []string
+	for _, s := range ra.Stages {
+		names = append(names, s.Name)
+	}
+	for _, s := range rb.Stages {
+		stagesB[s.Name] = s
+		if _, ok := stagesA[s.Name]; !ok {
+			names = append(names, s.Name)
+		}
+	}
+	if stage != "" {
+		if _, okA := stagesA[stage]; !okA {
+			if _, okB := stagesB[stage]; !okB {
+				return fmt.Errorf("neither run has a stage %s", stage)
+			}
+		}
+		names = []string{stage}
+	}
+
+	fmt.Printf("%s %s ↔ %s\n", cyan("Compare:"), ra.Run, rb.Run)
+	row := func(label, x, y string) {
+		mark := dim("=")
+		if x != y {
+			mark = yellow("≠")
+		}
+		fmt.Printf("  %s %-12s %s  │  %s\n", mark, label, x, y)
+	}
+	row("workflow", ra.Workflow, rb.Workflow)
+	row("requirement", shorten(ra.Requirement, 40), shorten(rb.Requirement, 40))
+	row("status", ra.Status, rb.Status)
+	row("duration", ra.Duration.Round(time.Second).String(), rb.Duration.Round(time.Second).String())
+	if ra.Usage != nil || rb.Usage != nil {
+		usage := func(r *runReport) string {
+			if r.Usage == nil {
+				return "-"
+			}
+			return formatUsage(r.Usage.Total)
+		}
+		row("usage", usage(ra), usage(rb))
+	}
+
+	for _, name := range names {
+		sa, okA := stagesA[name]
+		sb, okB := stagesB[name]
+		fmt.Printf("\n%s %s\n", cyan("▸"), name)
+		switch {
+		case !okA:
+			fmt.Printf("  %s\n", dim("only in "+rb.Run))
+			continue
+		case !okB:
+			fmt.Printf("  %s\n", dim("only in "+ra.Run))
+			continue
+		}
+		if sa.Status != sb.Status || sa.Runner != sb.Runner {
+			row("status", sa.Runner+" "+sa.Status, sb.Runner+" "+sb.Status)
+		}
+		if sa.Verdict != sb.Verdict {
+			row("verdict", sa.Verdict, sb.Verdict)
+		}
+		if sa.Output == sb.Output {
+			fmt.Printf("  %s\n", dim("output identical"))
+			continue
+		}
+		printDiff(lineDiff(sa.Output, sb.Output))
+	}
+	return nil
+}
+
+// printDiff prints the changed lines of a lineDiff with a line of context
+// around each change.
+func printDiff(diff string) {
+	lines := strings.Split(strings.TrimRight(diff, "\n"), "\n")
+	changed := func(i int) bool { return i >= 0 && i < len(lines) && !strings.HasPrefix(lines[i], "  ") }
+	gap := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+ "):
+			fmt.Printf("  %s\n", green(line))
+		case strings.HasPrefix(line, "- "):
+			fmt.Printf("  %s\n", red(line))
+		case changed(i-1) || changed(i+1):
+			fmt.Printf("  %s\n", dim(line))
+		default:
+			if !gap {
+				fmt.Printf("  %s\n", dim("  ..."))
+			}
+			gap = true
+			continue
+		}
+		gap = false
+	}
+}
+
+// pruneRuns deletes old run directories: those started more than
+// --older-than ago, and everything beyond the newest --keep. With both,
+// a run goes only if it is old and not among the newest kept. The latest
+// run is never deleted.
+func pruneRuns(args []string) error {
+	var olderThan time.Duration
+	keep := -1
+	dryRun, yes := false, false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dry-run":
+			dryRun = true
+		case "--yes", "-y":
+			yes = true
+		case "--older-than", "--keep":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a value", args[i])
+			}
+			var err error
+			if args[i] == "--keep" {
+				keep, err = strconv.Atoi(args[i+1])
+				if err == nil && keep < 0 {
+					err = fmt.Errorf("--keep must be 0 or more")
+				}
+			} else {
+				olderThan, err = parseAge(args[i+1])
+			}
+			if err != nil {
+				return err
+			}
+			i++
+		default:
+			return fmt.Errorf("unknown option %s (usage: prune [--older-than 30d] [--keep 20] [--dry-run] [--yes])", args[i])
+		}
+	}
+	if olderThan == 0 && keep < 0 {
+		return fmt.Errorf("prune needs --older-than, --keep or both")
+	}
+
+	latest := ""
+	if dir := findLatestWorkflow(); dir != "" {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			latest = filepath.Base(resolved)
+		}
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var doomed []*runInfo
+	for i, r := range listRuns() {
+		if r.Name == latest || (keep >= 0 && i < keep) {
+			continue
+		}
+		if olderThan > 0 && !r.Started.Before(cutoff) {
+			continue
+		}
+		doomed = append(doomed, r)
+	}
+	if len(doomed) == 0 {
+		fmt.Println(dim("Nothing to prune"))
+		return nil
+	}
+	for _, r := range doomed {
+		fmt.Printf("  %s %s\n", red("-"), r.line())
+	}
+	if dryRun {
+		fmt.Printf("%s Would delete %d run(s)\n", dim("│"), len(doomed))
+		return nil
+	}
+	if !yes {
+		fmt.Printf("%s Delete %d run(s)? [y/N]: ", yellow("?"), len(doomed))
+		var input string
+		fmt.Scanln(&input)
+		if input != "y" && input != "Y" {
+			fmt.Printf("%s Cancelled\n", dim("○"))
+			return nil
+		}
+	}
+	deleted := 0
+	for _, r := range doomed {
+		if err := os.RemoveAll(r.Dir); err != nil {
+			fmt.Printf("%s %s: %v\n", red("✗"), r.Name, err)
+			continue
+		}
+		deleted++
+	}
+	fmt.Printf("%s Deleted %d run(s)\n", green("✓"), deleted)
+	return nil
+}
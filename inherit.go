@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// StagePatch modifies one stage of the workflow named in Workflow.Extends.
+type StagePatch struct {
+	Stage  string          `json:"stage"`            // Target stage name
+	Set    json.RawMessage `json:"set,omitempty"`    // Stage fields to override
+	Before *Stage          `json:"before,omitempty"` // New stage inserted before the target (or first if no target)
+	After  *Stage          `json:"after,omitempty"`  // New stage inserted after the target (or last if no target)
+	Remove bool            `json:"remove,omitempty"`
+}
+
+// mergeWorkflows layers overrides on top of base. A workflow with Extends
+// starts from its parent (another override, or the base definition of that
+// name) and applies its patches; one without replaces the base outright.
+// Workflows that fail to merge are left out and reported.
+func mergeWorkflows(base, overrides map[string]Workflow) (map[string]Workflow, []error) {
+	result := make(map[string]Workflow, len(base))
+	for name, wf := range base {
+		result[name] = wf
+	}
+
+	var errs []error
+	state := make(map[string]int) // 0 = pending, 1 = resolving, 2 = done
+	var resolve func(name string) error
+	resolve = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("workflow %s: extends cycle", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		defer func() { state[name] = 2 }()
+
+		ov := overrides[name]
+		if ov.Disabled {
+			delete(result, name)
+			return nil
+		}
+		if ov.Extends == "" {
+			result[name] = ov
+			return nil
+		}
+
+		var parent Workflow
+		var ok bool
+		if _, isOverride := overrides[ov.Extends]; isOverride && ov.Extends != name {
+			if err := resolve(ov.Extends); err != nil {
+				return err
+			}
+			parent, ok = result[ov.Extends]
+		} else {
+			parent, ok = base[ov.Extends]
+		}
+		if !ok {
+			return fmt.Errorf("workflow %s: extends unknown workflow %s", name, ov.Extends)
+		}
+
+		merged, err := extendWorkflow(parent, ov)
+		if err != nil {
+			return fmt.Errorf("workflow %s: %w", name, err)
+		}
+		result[name] = merged
+		return nil
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := resolve(name); err != nil {
+			delete(result, name)
+			errs = append(errs, err)
+		}
+	}
+	return result, errs
+}
+
+func extendWorkflow(parent, child Workflow) (Workflow, error) {
+	wf := parent
+	wf.Extends = child.Extends
+	wf.Patches = nil
+	if child.Name != "" {
+		wf.Name = child.Name
+	}
+	if len(child.Loops) > 0 {
+		wf.Loops = child.Loops
+	}
+	if len(child.Params) > 0 {
+		wf.Params = child.Params
+	}
+	if child.Budget != nil {
+		wf.Budget = child.Budget
+	}
+
+	stages := child.Stages
+	if len(stages) == 0 {
+		stages = copyStages(parent.Stages)
+	}
+
+	for _, p := range child.Patches {
+		idx := -1
+		if p.Stage != "" {
+			for i, s := range stages {
+				if s.Name == p.Stage {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				return wf, fmt.Errorf("patch targets unknown stage %s", p.Stage)
+			}
+		}
+
+		if len(p.Set) > 0 {
+			if idx < 0 {
+				return wf, fmt.Errorf("set needs a target stage")
+			}
+			if err := json.Unmarshal(p.Set, &stages[idx]); err != nil {
+				return wf, fmt.Errorf("stage %s: %w", p.Stage, err)
+			}
+		}
+
+		if p.Remove {
+			if idx < 0 {
+				return wf, fmt.Errorf("remove needs a target stage")
+			}
+			stages = append(stages[:idx], stages[idx+1:]...)
+			idx--
+		}
+
+		if p.Before != nil {
+			at := idx
+			if idx < 0 {
+				at = 0
+			}
+			stages = insertStage(stages, at, *p.Before)
+			if idx >= 0 {
+				idx++
+			}
+		}
+		if p.After != nil {
+			at := idx + 1
+			if p.Stage == "" {
+				at = len(stages)
+			}
+			stages = insertStage(stages, at, *p.After)
+		}
+	}
+
+	wf.Stages = stages
+	return wf, nil
+}
+
+func insertStage(stages []Stage, at int, s Stage) []Stage {
+	stages = append(stages, Stage{})
+	copy(stages[at+1:], stages[at:])
+	stages[at] = s
+	return stages
+}
+
+// copyStages deep-copies stages so patches never write through to the
+// parent definition.
+func copyStages(stages []Stage) []Stage {
+	out := make([]Stage, len(stages))
+	for i, s := range stages {
+		if s.Inputs != nil {
+			inputs := make(map[string]string, len(s.Inputs))
+			for k, v := range s.Inputs {
+				inputs[k] = v
+			}
+			s.Inputs = inputs
+		}
+		if s.Env != nil {
+			env := make(map[string]string, len(s.Env))
+			for k, v := range s.Env {
+				env[k] = v
+			}
+			s.Env = env
+		}
+		s.DependsOn = append([]string(nil), s.DependsOn...)
+		s.Pre = append([]Hook(nil), s.Pre...)
+		s.Post = append([]Hook(nil), s.Post...)
+		if s.Budget != nil {
+			b := *s.Budget
+			s.Budget = &b
+		}
+		if s.Schema != nil {
+			fields := make(map[string]SchemaField, len(s.Schema.Fields))
+			for k, f := range s.Schema.Fields {
+				f.Enum = append([]string(nil), f.Enum...)
+				fields[k] = f
+			}
+			s.Schema = &OutputSchema{Fields: fields}
+		}
+		out[i] = s
+	}
+	return out
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stageNames(wf Workflow) string {
+	names := make([]string, len(wf.Stages))
+	for i, s := range wf.Stages {
+		names[i] = s.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestExtendWorkflow(t *testing.T) {
+	parent := Workflow{Name: "Base", Stages: []Stage{
+		{Name: "plan", Backend: "gemini", Prompt: "plan it"},
+		{Name: "code", Backend: "claude", Prompt: "code it"},
+		{Name: "review", Backend: "kiro", Prompt: "review it"},
+	}}
+
+	tests := []struct {
+		name    string
+		patches string
+		want    string // Stage names after patching
+		err     string
+	}{
+		{"no patches", `[]`, "plan,code,review", ""},
+		{"remove", `[{"stage": "review", "remove": true}]`, "plan,code", ""},
+		{"before target", `[{"stage": "code", "before": {"name": "lint"}}]`, "plan,lint,code,review", ""},
+		{"after target", `[{"stage": "plan", "after": {"name": "security"}}]`, "plan,security,code,review", ""},
+		{"before and after", `[{"stage": "code", "before": {"name": "a"}, "after": {"name": "b"}}]`, "plan,a,code,b,review", ""},
+		{"first and last", `[{"before": {"name": "first"}}, {"after": {"name": "last"}}]`, "first,plan,code,review,last", ""},
+		{"remove then insert", `[{"stage": "code", "remove": true, "after": {"name": "code2"}}]`, "plan,code2,review", ""},
+		{"unknown stage", `[{"stage": "deploy", "remove": true}]`, "", "unknown stage deploy"},
+		{"set without target", `[{"set": {"backend": "kiro"}}]`, "", "set needs a target stage"},
+		{"remove without target", `[{"remove": true}]`, "", "remove needs a target stage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var child Workflow
+			child.Extends = "base"
+			if err := json.Unmarshal([]byte(tt.patches), &child.Patches); err != nil {
+				t.Fatal(err)
+			}
+			got, err := extendWorkflow(parent, child)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := stageNames(got); names != tt.want {
+				t.Errorf("stages = %s, want %s", names, tt.want)
+			}
+		})
+	}
+	if names := stageNames(parent); names != "plan,code,review" {
+		t.Errorf("patches changed the parent: %s", names)
+	}
+}
+
+func TestExtendWorkflowSet(t *testing.T) {
+	parent := Workflow{Name: "Base", Stages: []Stage{{Name: "code", Backend: "claude", Prompt: "code it", Inputs: map[string]string{"k": "v"}}}}
+	child := Workflow{Extends: "base", Patches: []StagePatch{{Stage: "code", Set: json.RawMessage(`{"backend": "kiro", "inputs": {"k": "w"}}`)}}}
+	got, err := extendWorkflow(parent, child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := got.Stages[0]
+	if s.Backend != "kiro" || s.Prompt != "code it" || s.Inputs["k"] != "w" {
+		t.Errorf("got %+v, want backend kiro, prompt kept, input k=w", s)
+	}
+	if parent.Stages[0].Backend != "claude" || parent.Stages[0].Inputs["k"] != "v" {
+		t.Errorf("set wrote through to the parent: %+v", parent.Stages[0])
+	}
+}
+
+func TestExtendWorkflowSetSchema(t *testing.T) {
+	parent := Workflow{Name: "Base", Stages: []Stage{{Name: "review", Prompt: "review it", Schema: &OutputSchema{Fields: map[string]SchemaField{
+		"status": {Enum: []string{"APPROVED", "NEEDS_CHANGES"}, Required: true},
+	}}}}}
+	child := Workflow{Extends: "base", Patches: []StagePatch{{Stage: "review", Set: json.RawMessage(
+		`{"schema": {"fields": {"status": {"enum": ["OK", "NOT_OK"], "required": true}, "score": {"type": "number", "required": true}}}}`,
+	)}}}
+	got, err := extendWorkflow(parent, child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := got.Stages[0].Schema.Fields
+	if _, ok := fields["score"]; !ok || fields["status"].Enum[0] != "OK" {
+		t.Errorf("patched schema = %+v, want score added and status enum replaced", fields)
+	}
+	want := map[string]SchemaField{"status": {Enum: []string{"APPROVED", "NEEDS_CHANGES"}, Required: true}}
+	if !reflect.DeepEqual(parent.Stages[0].Schema.Fields, want) {
+		t.Errorf("set wrote through to the parent's schema: %+v", parent.Stages[0].Schema.Fields)
+	}
+}
+
+func TestMergeWorkflows(t *testing.T) {
+	base := map[string]Workflow{
+		"feature": {Name: "Feature", Stages: []Stage{{Name: "plan"}, {Name: "code"}}},
+		"docs":    {Name: "Docs", Stages: []Stage{{Name: "write"}}},
+	}
+	tests := []struct {
+		name      string
+		overrides map[string]Workflow
+		want      map[string]string // Workflow -> stage names; missing means absent
+		err       string
+	}{
+		{
+			name: "replace outright",
+			overrides: map[string]Workflow{
+				"feature": {Name: "Mine", Stages: []Stage{{Name: "only"}}},
+			},
+			want: map[string]string{"feature": "only", "docs": "write"},
+		},
+		{
+			name: "extend own name starts from the built-in",
+			overrides: map[string]Workflow{
+				"feature": {Extends: "feature", Patches: []StagePatch{{After: &Stage{Name: "ship"}}}},
+			},
+			want: map[string]string{"feature": "plan,code,ship", "docs": "write"},
+		},
+		{
+			name: "chain of overrides",
+			overrides: map[string]Workflow{
+				"a": {Extends: "feature", Patches: []StagePatch{{After: &Stage{Name: "a"}}}},
+				"b": {Extends: "a", Patches: []StagePatch{{After: &Stage{Name: "b"}}}},
+			},
+			want: map[string]string{"feature": "plan,code", "docs": "write", "a": "plan,code,a", "b": "plan,code,a,b"},
+		},
+		{
+			name: "disabled",
+			overrides: map[string]Workflow{
+				"docs": {Disabled: true},
+			},
+			want: map[string]string{"feature": "plan,code"},
+		},
+		{
+			name: "cycle",
+			overrides: map[string]Workflow{
+				"x": {Extends: "y"},
+				"y": {Extends: "x"},
+			},
+			want: map[string]string{"feature": "plan,code", "docs": "write"},
+			err:  "extends cycle",
+		},
+		{
+			name: "unknown parent",
+			overrides: map[string]Workflow{
+				"x": {Extends: "nope"},
+			},
+			want: map[string]string{"feature": "plan,code", "docs": "write"},
+			err:  "extends unknown workflow nope",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := mergeWorkflows(base, tt.overrides)
+			if tt.err == "" && len(errs) > 0 {
+				t.Fatalf("errors: %v", errs)
+			}
+			if tt.err != "" && (len(errs) == 0 || !strings.Contains(errs[0].Error(), tt.err)) {
+				t.Fatalf("errors = %v, want %q", errs, tt.err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %d workflows, want %d", len(got), len(tt.want))
+			}
+			for name, stages := range tt.want {
+				wf, ok := got[name]
+				if !ok {
+					t.Errorf("workflow %s missing", name)
+					continue
+				}
+				if names := stageNames(wf); names != stages {
+					t.Errorf("%s: stages = %s, want %s", name, names, stages)
+				}
+			}
+		})
+	}
+	if names := stageNames(base["feature"]); names != "plan,code" {
+		t.Errorf("merge changed the base: %s", names)
+	}
+}
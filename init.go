@@ -11,6 +11,7 @@ const localConfigDir = ".ai-proxy"
 const localConfigFile = ".ai-proxy/config.json"
 
 type ProjectConfig struct {
+	Version   int                 `json:"version"`
 	Workflows map[string]Workflow `json:"workflows"`
 }
 
@@ -20,6 +21,7 @@ func initProject() error {
 	}
 
 	cfg := ProjectConfig{
+		Version: projectConfigVersion,
 		Workflows: map[string]Workflow{
 			"feature": defaultWorkflows["feature"],
 			"bugfix":  defaultWorkflows["bugfix"],
@@ -37,29 +39,60 @@ func initProject() error {
 }
 
 func loadProjectConfig() {
-	if data, err := os.ReadFile(localConfigFile); err == nil {
-		var cfg ProjectConfig
-		if json.Unmarshal(data, &cfg) == nil {
-			for name, wf := range cfg.Workflows {
-				defaultWorkflows[name] = wf
+	path := findProjectConfig()
+	if path == "" {
+		return
+	}
+	cfg, err := readProjectConfig(path)
+	if err != nil {
+		fmt.Printf("%s %s: %v\n", yellow("!"), path, err)
+		return
+	}
+	merged, errs := mergeWorkflows(defaultWorkflows, cfg.Workflows)
+	for _, err := range errs {
+		fmt.Printf("%s %s: %v\n", yellow("!"), path, err)
+	}
+	defaultWorkflows = merged
+	for name := range cfg.Workflows {
+		if wf, ok := merged[name]; ok {
+			wf.Key = name
+			if err := wf.validate(); err != nil {
+				fmt.Printf("%s %s: workflow %s: %v\n", yellow("!"), path, name, err)
 			}
-			fmt.Printf("%s Loaded project config\n", dim("●"))
-			return
 		}
 	}
+	for name, wf := range cfg.Workflows {
+		if wf.Disabled {
+			delete(workflowOrigins, name)
+			continue
+		}
+		if _, ok := merged[name]; !ok {
+			continue
+		}
+		note := ""
+		if wf.Extends != "" {
+			note = "extends " + wf.Extends
+		}
+		warnShadow("workflow", name, workflowOrigins.set(name, path, note))
+	}
+	if path == localConfigFile {
+		fmt.Printf("%s Loaded project config\n", dim("●"))
+	} else {
+		fmt.Printf("%s Loaded config from %s\n", dim("●"), path)
+	}
+}
 
+// findProjectConfig looks for .ai-proxy/config.json in the current directory
+// and up to four parents.
+func findProjectConfig() string {
+	if _, err := os.Stat(localConfigFile); err == nil {
+		return localConfigFile
+	}
 	dir, _ := os.Getwd()
 	for i := 0; i < 5; i++ {
 		configPath := filepath.Join(dir, localConfigFile)
-		if data, err := os.ReadFile(configPath); err == nil {
-			var cfg ProjectConfig
-			if json.Unmarshal(data, &cfg) == nil {
-				for name, wf := range cfg.Workflows {
-					defaultWorkflows[name] = wf
-				}
-				fmt.Printf("%s Loaded config from %s\n", dim("●"), configPath)
-				return
-			}
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath
 		}
 		parent := filepath.Dir(dir)
 		if parent == dir {
@@ -67,4 +100,24 @@ func loadProjectConfig() {
 		}
 		dir = parent
 	}
+	return ""
+}
+
+func readProjectConfig(path string) (*ProjectConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	migrated, applied, err := migrateConfigData(data, projectMigrations, projectConfigVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) > 0 {
+		fmt.Printf("%s %s uses an older format, run `proxy config migrate` to update it\n", dim("●"), path)
+	}
+	var cfg ProjectConfig
+	if err := json.Unmarshal(migrated, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
 }
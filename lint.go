@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	lintError   = "error"   // The run would fail
+	lintWarning = "warning" // The run works, but probably not as intended
+)
+
+// lintIssue is one finding of the workflow linter.
+type lintIssue struct {
+	Workflow string
+	Stage    string // Empty for the workflow as a whole
+	Severity string
+	Message  string
+}
+
+func (i lintIssue) String() string {
+	if i.Stage == "" {
+		return i.Message
+	}
+	return fmt.Sprintf("stage %s: %s", i.Stage, i.Message)
+}
+
+// legacyFiles are the output files each legacy placeholder is read from
+// (see legacyPromptVars).
+var legacyFiles = map[string][]string{
+	"PlanContent":     {"plan.md", "analysis.md", "api-plan.md", "refactor-plan.md"},
+	"TasksContent":    {"tasks.md", "fix-tasks.md", "refactor-tasks.md"},
+	"ReviewContent":   {"review.md"},
+	"VerifyContent":   {"verify.md"},
+	"DiffContent":     {"diff.md"},
+	"SecurityContent": {"security.md"},
+}
+
+// lint checks a workflow for mistakes that validate doesn't catch because
+// the definition is well-formed: references to backends, skills, stages,
+// parameters and outputs that won't be there when the stage runs.
+func (wf *Workflow) lint() []lintIssue {
+	var issues []lintIssue
+	add := func(stage, severity, format string, args ...interface{}) {
+		issues = append(issues, lintIssue{Workflow: wf.Key, Stage: stage, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if err := wf.validate(); err != nil {
+		add("", lintError, "%v", err)
+	}
+
+	for i := range wf.Stages {
+		s := &wf.Stages[i]
+		switch {
+		case s.Backend == "auto":
+			if s.Name != "verify" {
+				add(s.Name, lintError, `backend "auto" only works for a stage named verify`)
+			}
+		case s.Backend != "":
+			if _, ok := config.Backends[s.Backend]; !ok {
+				add(s.Name, lintError, "unknown backend %s", s.Backend)
+			}
+		}
+		if s.Skill != "" && getSkill(s.Skill) == nil {
+			add(s.Name, lintError, "skill %s is not installed", s.Skill)
+		}
+		if s.Type != "shell" && s.Workflow == "" && s.Skill == "" && s.Backend != "auto" && !s.Interactive && strings.TrimSpace(s.Prompt) == "" {
+			add(s.Name, lintError, "nothing to run: no prompt, skill, command or workflow")
+		}
+		if s.Prompt != "" && (s.Skill != "" || s.Workflow != "" || s.Type == "shell") {
+			add(s.Name, lintWarning, "prompt is ignored by %s stages", s.runner())
+		}
+		for _, dep := range s.DependsOn {
+			if dep == s.Name {
+				add(s.Name, lintError, "depends on itself")
+			}
+		}
+
+		if s.Prompt != "" && s.Skill == "" && s.Workflow == "" && s.Type != "shell" {
+			for _, msg := range wf.lintTemplate(i, "prompt", s.Prompt) {
+				issues = append(issues, lintIssue{Workflow: wf.Key, Stage: s.Name, Severity: msg.Severity, Message: msg.Message})
+			}
+		}
+		keys := make([]string, 0, len(s.Inputs))
+		for k := range s.Inputs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, msg := range wf.lintTemplate(i, "input "+k, s.Inputs[k]) {
+				issues = append(issues, lintIssue{Workflow: wf.Key, Stage: s.Name, Severity: msg.Severity, Message: msg.Message})
+			}
+		}
+
+		if s.Condition != "" {
+			if c, err := parseCondition(s.Condition); err == nil {
+				for _, name := range c.stages {
+					parent, _, _ := strings.Cut(name, subResultSep)
+					if k, err := wf.stageIndex(parent); err == nil && !wf.runsBefore(k, i) {
+						add(s.Name, lintWarning, "condition %q refers to %s, which hasn't run when it is checked", s.Condition, parent)
+					}
+				}
+			}
+		}
+
+		if s.ReviewLoop {
+			review := -1
+			for k := 0; k < i; k++ {
+				if wf.Stages[k].Name == "code-review" {
+					review = k
+					break
+				}
+			}
+			switch {
+			case review < 0:
+				add(s.Name, lintWarning, "reviewLoop has no code-review stage before it to loop back to, so it never loops")
+			case len(wf.Loops) > 0:
+				add(s.Name, lintWarning, "reviewLoop is ignored because the workflow defines loops")
+			}
+		}
+	}
+	return issues
+}
+
+// lintTemplate checks the placeholders of a template used by stage idx.
+func (wf *Workflow) lintTemplate(idx int, what, text string) []lintIssue {
+	var issues []lintIssue
+	add := func(severity, format string, args ...interface{}) {
+		issues = append(issues, lintIssue{Severity: severity, Message: what + ": " + fmt.Sprintf(format, args...)})
+	}
+
+	text = dashedStageRef.ReplaceAllString(text, `(index .Stages "$1")`)
+	t, err := template.New(what).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		add(lintError, "%v", err)
+		return issues
+	}
+	var refs [][]string
+	for _, tt := range t.Templates() {
+		if tt.Tree != nil {
+			collectRefs(tt.Tree.Root, true, &refs)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		key := strings.Join(ref, ".")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		placeholder := "{{." + key + "}}"
+
+		switch ref[0] {
+		case "Requirement", "ProjectContext":
+		case "Params":
+			if len(ref) > 1 && wf.param(ref[1]) == nil {
+				add(lintError, "%s: workflow has no parameter %s", placeholder, ref[1])
+			}
+		case "Stages":
+			if len(ref) < 2 {
+				continue
+			}
+			name := ref[1]
+			if len(ref) > 2 {
+				switch ref[2] {
+				case "Output", "File":
+				case "Verdict":
+					k, err := wf.stageIndex(name)
+					switch {
+					case err != nil:
+					case wf.Stages[k].Schema == nil && len(ref) > 3:
+						add(lintError, "%s: stage %s has no schema, so it has no verdict", placeholder, name)
+					case wf.Stages[k].Schema == nil:
+						add(lintWarning, "%s: stage %s has no schema, so it has no verdict", placeholder, name)
+					case len(ref) > 3:
+						if _, ok := wf.Stages[k].Schema.Fields[ref[3]]; !ok {
+							add(lintError, "%s: stage %s's schema has no field %s", placeholder, name, ref[3])
+						}
+					}
+				default:
+					add(lintError, "%s: stages have Output, File and Verdict, not %s", placeholder, ref[2])
+				}
+			}
+			if name == "project-context" || name == "diff" || name == "verify" {
+				continue
+			}
+			parent, _, sub := strings.Cut(name, subResultSep)
+			k, err := wf.stageIndex(parent)
+			switch {
+			case err != nil:
+				add(lintError, "%s: no stage %s", placeholder, parent)
+			case sub && wf.Stages[k].Workflow == "":
+				add(lintError, "%s: stage %s is not a sub-workflow", placeholder, parent)
+			case k == idx && !wf.inLoop(idx):
+				add(lintWarning, "%s: a stage's own output is always empty in its prompt", placeholder)
+			case k != idx && !wf.runsBefore(k, idx):
+				add(lintWarning, "%s: stage %s hasn't run yet when %s runs, so this is empty", placeholder, parent, wf.Stages[idx].Name)
+			}
+		default:
+			files, ok := legacyFiles[ref[0]]
+			if !ok {
+				add(lintError, "unknown placeholder %s", placeholder)
+				continue
+			}
+			var producers, early []string
+			for k, s := range wf.Stages {
+				if !wf.produces(k, files) {
+					continue
+				}
+				producers = append(producers, s.Name)
+				if k != idx && wf.runsBefore(k, idx) {
+					early = append(early, s.Name)
+				}
+			}
+			switch {
+			case len(producers) == 0:
+				add(lintWarning, "%s: no stage writes %s, so this is always empty", placeholder, strings.Join(files, " or "))
+			case len(early) == 0:
+				add(lintWarning, "%s: only written by %s, which hasn't run yet", placeholder, strings.Join(producers, ", "))
+			}
+		}
+	}
+	return issues
+}
+
+// produces reports whether stage k writes one of files: as its outputFile,
+// or diff.md for the auto verify stage.
+func (wf *Workflow) produces(k int, files []string) bool {
+	s := wf.Stages[k]
+	for _, f := range files {
+		if s.OutputFile == f || (f == "diff.md" && s.Backend == "auto" && s.Name == "verify") {
+			return true
+		}
+	}
+	return false
+}
+
+// runsBefore reports whether stage k has run by the time stage idx runs:
+// an earlier stage outside its parallel group, or in a DAG an ancestor.
+// A stage in the same loop as idx counts too, from the second iteration on.
+func (wf *Workflow) runsBefore(k, idx int) bool {
+	if wf.isDAG() {
+		deps := map[string]bool{}
+		var walk func(name string)
+		walk = func(name string) {
+			j, err := wf.stageIndex(name)
+			if err != nil {
+				return
+			}
+			for _, d := range wf.Stages[j].DependsOn {
+				if !deps[d] {
+					deps[d] = true
+					walk(d)
+				}
+			}
+		}
+		walk(wf.Stages[idx].Name)
+		return deps[wf.Stages[k].Name]
+	}
+	if p := wf.Stages[idx].Parallel; p != "" && wf.Stages[k].Parallel == p && wf.sameGroup(k, idx) {
+		return false
+	}
+	if k < idx {
+		return true
+	}
+	spans, _ := wf.loopSpans()
+	for _, sp := range spans {
+		if k >= sp.Start && k <= sp.End && idx >= sp.Start && idx <= sp.End {
+			return true
+		}
+	}
+	return false
+}
+
+// sameGroup reports whether stages a and b are in one run of adjacent
+// stages with the same parallel group.
+func (wf *Workflow) sameGroup(a, b int) bool {
+	if a > b {
+		a, b = b, a
+	}
+	for k := a; k <= b; k++ {
+		if wf.Stages[k].Parallel != wf.Stages[a].Parallel {
+			return false
+		}
+	}
+	return true
+}
+
+func (wf *Workflow) inLoop(idx int) bool {
+	spans, _ := wf.loopSpans()
+	for _, sp := range spans {
+		if idx >= sp.Start && idx <= sp.End {
+			return true
+		}
+	}
+	return false
+}
+
+// collectRefs gathers the data fields a template reads, as paths from the
+// top-level data ("Stages", "plan", "Output"). Fields read where dot has
+// been rebound by range or with are relative to something else and are
+// left out, unless reached through $.
+func collectRefs(node parse.Node, rooted bool, refs *[][]string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			collectRefs(c, rooted, refs)
+		}
+	case *parse.ActionNode:
+		collectRefs(n.Pipe, rooted, refs)
+	case *parse.TemplateNode:
+		collectRefs(n.Pipe, rooted, refs)
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, rooted, rooted, refs)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, false, rooted, refs)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, false, rooted, refs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectRefs(cmd, rooted, refs)
+		}
+	case *parse.CommandNode:
+		// (index .Stages "name") reads a stage like .Stages.name
+		if len(n.Args) >= 3 {
+			if id, ok := n.Args[0].(*parse.IdentifierNode); ok && id.Ident == "index" {
+				if f, ok := n.Args[1].(*parse.FieldNode); ok && rooted && len(f.Ident) == 1 && f.Ident[0] == "Stages" {
+					if s, ok := n.Args[2].(*parse.StringNode); ok {
+						*refs = append(*refs, []string{"Stages", s.Text})
+					}
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			collectRefs(arg, rooted, refs)
+		}
+	case *parse.ChainNode:
+		// (index .Stages "name").Output
+		if cmd, ok := chainIndex(n.Node); ok && rooted && len(n.Field) > 0 {
+			*refs = append(*refs, append([]string{"Stages", cmd}, n.Field...))
+		}
+		collectRefs(n.Node, rooted, refs)
+	case *parse.FieldNode:
+		if rooted {
+			*refs = append(*refs, n.Ident)
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			*refs = append(*refs, n.Ident[1:])
+		}
+	}
+}
+
+func collectBranch(n *parse.BranchNode, bodyRooted, rooted bool, refs *[][]string) {
+	collectRefs(n.Pipe, rooted, refs)
+	collectRefs(n.List, bodyRooted, refs)
+	if n.ElseList != nil {
+		collectRefs(n.ElseList, rooted, refs)
+	}
+}
+
+// chainIndex returns the stage name of an (index .Stages "name") node.
+func chainIndex(node parse.Node) (string, bool) {
+	pipe, ok := node.(*parse.PipeNode)
+	if !ok || len(pipe.Cmds) != 1 {
+		return "", false
+	}
+	args := pipe.Cmds[0].Args
+	if len(args) != 3 {
+		return "", false
+	}
+	id, ok := args[0].(*parse.IdentifierNode)
+	f, ok2 := args[1].(*parse.FieldNode)
+	s, ok3 := args[2].(*parse.StringNode)
+	if !ok || !ok2 || !ok3 || id.Ident != "index" || len(f.Ident) != 1 || f.Ident[0] != "Stages" {
+		return "", false
+	}
+	return s.Text, true
+}
+
+// lintWithSubs lints a workflow and the workflows it runs as stages.
+func (wf *Workflow) lintWithSubs() []lintIssue {
+	issues := wf.lint()
+	seen := map[string]bool{wf.Key: true}
+	var walk func(w *Workflow)
+	walk = func(w *Workflow) {
+		for _, s := range w.Stages {
+			if s.Workflow == "" || seen[s.Workflow] {
+				continue
+			}
+			seen[s.Workflow] = true
+			if sub := getWorkflow(s.Workflow); sub != nil {
+				issues = append(issues, sub.lint()...)
+				walk(sub)
+			}
+		}
+	}
+	walk(wf)
+	return issues
+}
+
+// checkLint prints a workflow's lint findings before a run and fails if
+// any of them is an error.
+func checkLint(wf *Workflow) error {
+	errs := 0
+	for _, issue := range wf.lintWithSubs() {
+		if issue.Severity == lintError {
+			errs++
+			fmt.Printf("%s %s: %s\n", red("✗"), issue.Workflow, issue)
+		} else {
+			fmt.Printf("%s %s: %s\n", yellow("!"), issue.Workflow, issue)
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d lint error(s); see /workflow lint %s", errs, wf.Key)
+	}
+	return nil
+}
+
+// lintWorkflows is /workflow lint: it checks the named workflows, or all of
+// them, and reports whether any has errors.
+func lintWorkflows(names []string) bool {
+	if len(names) == 0 {
+		for name, wf := range defaultWorkflows {
+			if !wf.Disabled {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+	failed := false
+	for _, name := range names {
+		wf := getWorkflow(name)
+		if wf == nil {
+			fmt.Printf("%s %s: unknown workflow\n", red("✗"), name)
+			failed = true
+			continue
+		}
+		issues := wf.lint()
+		if len(issues) == 0 {
+			fmt.Printf("%s %s\n", green("✓"), name)
+			continue
+		}
+		errs := 0
+		for _, issue := range issues {
+			if issue.Severity == lintError {
+				errs++
+			}
+		}
+		mark := yellow("!")
+		if errs > 0 {
+			mark = red("✗")
+			failed = true
+		}
+		fmt.Printf("%s %s %s\n", mark, name, dim(fmt.Sprintf("(%d error(s), %d warning(s))", errs, len(issues)-errs)))
+		if o := workflowOrigins[name]; o != nil {
+			fmt.Printf("    %s %s\n", dim("Source:"), o)
+		}
+		for _, issue := range issues {
+			sev := yellow(fmt.Sprintf("%-7s", issue.Severity))
+			if issue.Severity == lintError {
+				sev = red(fmt.Sprintf("%-7s", issue.Severity))
+			}
+			fmt.Printf("    %s %s\n", sev, issue)
+		}
+	}
+	return !failed
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lintResult formats issues as "severity stage: message" for comparison.
+func lintResult(issues []lintIssue) []string {
+	out := make([]string, len(issues))
+	for i, issue := range issues {
+		out[i] = fmt.Sprintf("%s %s", issue.Severity, issue)
+	}
+	return out
+}
+
+func TestLint(t *testing.T) {
+	config = defaultConfig()
+	review := &OutputSchema{Fields: map[string]SchemaField{"status": {Enum: []string{"APPROVED", "NEEDS_CHANGES"}, Required: true}}}
+
+	tests := []struct {
+		name   string
+		wf     Workflow
+		issues []string // Substrings of lintResult, one per expected issue
+	}{
+		{
+			name: "clean",
+			wf: Workflow{Params: []Param{{Name: "lang"}}, Stages: []Stage{
+				{Name: "plan", Backend: "gemini", Prompt: "{{.Requirement}} in {{.Params.lang}}", OutputFile: "plan.md"},
+				{Name: "code-review", Backend: "kiro", Prompt: "{{.PlanContent}} {{.Stages.plan.Output}}", Schema: review},
+				{Name: "fix", Backend: "claude", Prompt: "{{.Stages.code-review.Verdict.status}}", Condition: "not approved:code-review"},
+				{Name: "test", Type: "shell", ShellCommand: ShellCommand{Command: "go test ./..."}},
+			}},
+		},
+		{
+			name:   "unknown backend",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Backend: "nope", Prompt: "x"}}},
+			issues: []string{"error stage a: unknown backend nope"},
+		},
+		{
+			name:   "unknown budget fallback",
+			wf:     Workflow{Budget: &Budget{MaxCalls: 3, OnExceeded: "downgrade", Fallback: "nope"}, Stages: []Stage{{Name: "a", Prompt: "x"}}},
+			issues: []string{"error budget fallback: unknown backend nope"},
+		},
+		{
+			name:   "auto outside verify",
+			wf:     Workflow{Stages: []Stage{{Name: "check", Backend: "auto"}}},
+			issues: []string{`error stage check: backend "auto" only works for a stage named verify`},
+		},
+		{
+			name:   "missing skill",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Skill: "lint-test-no-such-skill"}}},
+			issues: []string{"error stage a: skill lint-test-no-such-skill is not installed"},
+		},
+		{
+			name:   "nothing to run",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Backend: "claude"}}},
+			issues: []string{"error stage a: nothing to run"},
+		},
+		{
+			name:   "ignored prompt",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Type: "shell", Prompt: "x", ShellCommand: ShellCommand{Command: "true"}}}},
+			issues: []string{"warning stage a: prompt is ignored by shell stages"},
+		},
+		{
+			name:   "misspelled placeholder",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Prompt: "{{.Requirment}}"}}},
+			issues: []string{"error stage a: prompt: unknown placeholder {{.Requirment}}"},
+		},
+		{
+			name:   "template syntax",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Prompt: "{{.Requirement"}}},
+			issues: []string{"error stage a: prompt: "},
+		},
+		{
+			name:   "unknown param",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Prompt: "{{.Params.lang}}"}}},
+			issues: []string{"error stage a: prompt: {{.Params.lang}}: workflow has no parameter lang"},
+		},
+		{
+			name:   "input templates",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Workflow: "docs", Inputs: map[string]string{"x": "{{.Stages.nope.Output}}"}}}},
+			issues: []string{"error stage a: input x: {{.Stages.nope.Output}}: no stage nope"},
+		},
+		{
+			name: "later stage",
+			wf: Workflow{Stages: []Stage{
+				{Name: "a", Prompt: "{{.Stages.b.Output}}"},
+				{Name: "b", Prompt: "x"},
+			}},
+			issues: []string{"warning stage a: prompt: {{.Stages.b.Output}}: stage b hasn't run yet when a runs"},
+		},
+		{
+			name:   "own output",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Prompt: "{{.Stages.a.Output}}"}}},
+			issues: []string{"warning stage a: prompt: {{.Stages.a.Output}}: a stage's own output is always empty"},
+		},
+		{
+			name: "own output in a loop is fine",
+			wf: Workflow{
+				Stages: []Stage{
+					{Name: "code-review", Prompt: "{{.Stages.code-review.Output}}", Schema: review},
+					{Name: "fix", Prompt: "{{.Stages.code-review.Output}}"},
+				},
+				Loops: []Loop{{Name: "review", Body: []string{"code-review", "fix"}, Until: "approved:code-review"}},
+			},
+		},
+		{
+			name: "parallel sibling",
+			wf: Workflow{Stages: []Stage{
+				{Name: "a", Prompt: "x", Parallel: "g"},
+				{Name: "b", Prompt: "{{.Stages.a.Output}}", Parallel: "g"},
+			}},
+			issues: []string{"warning stage b: prompt: {{.Stages.a.Output}}: stage a hasn't run yet"},
+		},
+		{
+			name: "verdict without schema",
+			wf: Workflow{Stages: []Stage{
+				{Name: "r", Prompt: "x"},
+				{Name: "a", Prompt: "{{.Stages.r.Verdict}} {{.Stages.r.Verdict.status}}"},
+			}},
+			issues: []string{
+				"warning stage a: prompt: {{.Stages.r.Verdict}}: stage r has no schema",
+				"error stage a: prompt: {{.Stages.r.Verdict.status}}: stage r has no schema",
+			},
+		},
+		{
+			name: "verdict field not in schema",
+			wf: Workflow{Stages: []Stage{
+				{Name: "r", Prompt: "x", Schema: review},
+				{Name: "a", Prompt: "{{.Stages.r.Verdict.risk}}"},
+			}},
+			issues: []string{"error stage a: prompt: {{.Stages.r.Verdict.risk}}: stage r's schema has no field risk"},
+		},
+		{
+			name: "bad stage attribute",
+			wf: Workflow{Stages: []Stage{
+				{Name: "r", Prompt: "x"},
+				{Name: "a", Prompt: "{{.Stages.r.Result}}"},
+			}},
+			issues: []string{"error stage a: prompt: {{.Stages.r.Result}}: stages have Output, File and Verdict, not Result"},
+		},
+		{
+			name:   "legacy placeholder without producer",
+			wf:     Workflow{Stages: []Stage{{Name: "a", Prompt: "{{.TasksContent}}"}}},
+			issues: []string{"warning stage a: prompt: {{.TasksContent}}: no stage writes tasks.md"},
+		},
+		{
+			name: "legacy placeholder written later",
+			wf: Workflow{Stages: []Stage{
+				{Name: "a", Prompt: "{{.PlanContent}}"},
+				{Name: "plan", Prompt: "x", OutputFile: "plan.md"},
+			}},
+			issues: []string{"warning stage a: prompt: {{.PlanContent}}: only written by plan, which hasn't run yet"},
+		},
+		{
+			name: "dot rebound by range and with",
+			wf: Workflow{Params: []Param{{Name: "lang"}}, Stages: []Stage{
+				{Name: "plan", Prompt: "x"},
+				{Name: "a", Prompt: `{{range glob "*.go"}}{{.Name}}{{end}}{{with .Stages.plan}}{{.Output}} {{$.Requirment}}{{end}}`},
+			}},
+			issues: []string{"error stage a: prompt: unknown placeholder {{.Requirment}}"},
+		},
+		{
+			name: "condition on a later stage",
+			wf: Workflow{Stages: []Stage{
+				{Name: "a", Prompt: "x", Condition: "done:b"},
+				{Name: "b", Prompt: "x"},
+			}},
+			issues: []string{`warning stage a: condition "done:b" refers to b, which hasn't run when it is checked`},
+		},
+		{
+			name: "DAG ancestors and others",
+			wf: Workflow{Stages: []Stage{
+				{Name: "a", Prompt: "x"},
+				{Name: "b", Prompt: "x"},
+				{Name: "c", Prompt: "{{.Stages.a.Output}} {{.Stages.b.Output}}", DependsOn: []string{"a"}},
+			}},
+			issues: []string{"warning stage c: prompt: {{.Stages.b.Output}}: stage b hasn't run yet when c runs"},
+		},
+		{
+			name:   "reviewLoop without code-review",
+			wf:     Workflow{Stages: []Stage{{Name: "fix", Prompt: "x", ReviewLoop: true}}},
+			issues: []string{"warning stage fix: reviewLoop has no code-review stage before it"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.wf.Key = "test"
+			got := lintResult(tt.wf.lint())
+			if len(got) != len(tt.issues) {
+				t.Fatalf("got %d issue(s), want %d:\n%s", len(got), len(tt.issues), strings.Join(got, "\n"))
+			}
+			for i, want := range tt.issues {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("issue %d = %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLintBuiltinWorkflows(t *testing.T) {
+	config = defaultConfig()
+	for name := range defaultWorkflows {
+		if issues := getWorkflow(name).lint(); len(issues) > 0 {
+			t.Errorf("%s:\n%s", name, strings.Join(lintResult(issues), "\n"))
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Loop repeats a run of consecutive stages until a condition holds.
+type Loop struct {
+	Name          string   `json:"name"`
+	Body          []string `json:"body"`                    // Consecutive stage names
+	Until         string   `json:"until"`                   // Condition that ends the loop
+	CheckAfter    string   `json:"checkAfter,omitempty"`    // Stage after which Until is checked (default: first body stage)
+	MaxIterations int      `json:"maxIterations,omitempty"` // Default 3
+	OnExhausted   string   `json:"onExhausted,omitempty"`   // "ask" (default), "continue", "stop" or "fail"
+}
+
+// loopSpan is a Loop resolved to stage indexes.
+type loopSpan struct {
+	Loop
+	Start, End, Check int
+}
+
+func (sp *loopSpan) max() int {
+	if sp.MaxIterations <= 0 {
+		return 3
+	}
+	return sp.MaxIterations
+}
+
+// legacyReviewLoop turns the old reviewLoop flag into a loop: from the
+// "code-review" stage to the stage marked reviewLoop, until the review
+// approves, limited by the review stage's maxAttempts.
+func (wf *Workflow) legacyReviewLoop() *Loop {
+	review, fix := -1, -1
+	for i, s := range wf.Stages {
+		if s.Name == "code-review" && review < 0 {
+			review = i
+		}
+		if s.ReviewLoop && review >= 0 && i > review {
+			fix = i
+			break
+		}
+	}
+	if fix < 0 {
+		return nil
+	}
+	loop := &Loop{
+		Name:          "review",
+		Until:         "approved:code-review",
+		MaxIterations: wf.Stages[review].MaxAttempts,
+	}
+	for _, s := range wf.Stages[review : fix+1] {
+		loop.Body = append(loop.Body, s.Name)
+	}
+	return loop
+}
+
+// loopSpans resolves the workflow's loops to stage index ranges and checks
+// that each body is a run of consecutive stages not shared with another loop.
+func (wf *Workflow) loopSpans() ([]loopSpan, error) {
+	loops := wf.Loops
+	if len(loops) == 0 {
+		if l := wf.legacyReviewLoop(); l != nil {
+			loops = []Loop{*l}
+		}
+	}
+	if len(loops) > 0 && wf.isDAG() {
+		return nil, fmt.Errorf("loops are not supported in workflows using dependsOn")
+	}
+
+	index := make(map[string]int, len(wf.Stages))
+	for i, s := range wf.Stages {
+		index[s.Name] = i
+	}
+	owner := make(map[int]string)
+	var spans []loopSpan
+	for _, l := range loops {
+		if len(l.Body) == 0 {
+			return nil, fmt.Errorf("loop %s: empty body", l.Name)
+		}
+		if l.Until == "" {
+			return nil, fmt.Errorf("loop %s: missing until", l.Name)
+		}
+		sp := loopSpan{Loop: l}
+		for n, name := range l.Body {
+			i, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("loop %s: unknown stage %s", l.Name, name)
+			}
+			if n == 0 {
+				sp.Start = i
+			} else if i != sp.End+1 {
+				return nil, fmt.Errorf("loop %s: body stages must be consecutive (%s)", l.Name, strings.Join(l.Body, ", "))
+			}
+			if other, taken := owner[i]; taken {
+				return nil, fmt.Errorf("loop %s: stage %s is already in loop %s", l.Name, name, other)
+			}
+			owner[i] = l.Name
+			sp.End = i
+		}
+		sp.Check = sp.Start
+		if l.CheckAfter != "" {
+			i, ok := index[l.CheckAfter]
+			if !ok || i < sp.Start || i > sp.End {
+				return nil, fmt.Errorf("loop %s: checkAfter %s is not in the body", l.Name, l.CheckAfter)
+			}
+			sp.Check = i
+		}
+		switch l.OnExhausted {
+		case "", "ask", "continue", "stop", "fail":
+		default:
+			return nil, fmt.Errorf("loop %s: unknown onExhausted %q", l.Name, l.OnExhausted)
+		}
+		spans = append(spans, sp)
+	}
+	return spans, nil
+}
+
+func spanAt(spans []loopSpan, i int) *loopSpan {
+	for n := range spans {
+		if i >= spans[n].Start && i <= spans[n].End {
+			return &spans[n]
+		}
+	}
+	return nil
+}
+
+// loopNext decides which stage runs after stage i of a loop body. It
+// returns stop when the user (or onExhausted) ends the workflow.
+func (wf *Workflow) loopNext(ctx *WorkflowContext, sp *loopSpan, i int) (next int, stop bool, err error) {
+	if i != sp.Check {
+		if i == sp.End {
+			fmt.Printf("%s Back to %s (loop %s, iteration %d)\n\n", cyan("↻"), wf.Stages[sp.Start].Name, sp.Name, ctx.Loops[sp.Name]+1)
+			return sp.Start, false, nil
+		}
+		return i + 1, false, nil
+	}
+
+	if checkCondition(sp.Until, ctx) {
+		fmt.Printf("%s Loop %s done (%s)\n\n", green("✓"), sp.Name, sp.Until)
+		ctx.Loops[sp.Name] = 0
+		for _, s := range wf.Stages[i+1 : sp.End+1] {
+			ctx.skip(s.Name)
+		}
+		return sp.End + 1, false, nil
+	}
+
+	ctx.Loops[sp.Name]++
+	n := ctx.Loops[sp.Name]
+	if n >= sp.max() {
+		fmt.Printf("%s Loop %s: max iterations reached (%d/%d)\n", yellow("!"), sp.Name, n, sp.max())
+		action := sp.OnExhausted
+		if (action == "" || action == "ask") && runOpts.NonInteractive {
+			action = runOpts.OnExhausted
+			if action == "" {
+				action = "stop"
+			}
+			fmt.Printf("%s Non-interactive: %s\n", dim("│"), action)
+		}
+		if action == "" || action == "ask" {
+			switch ask(fmt.Sprintf("%s Continue looping? [y/N] or [s]kip rest of loop: ", yellow("?")), "") {
+			case "y":
+				action = "retry"
+			case "s":
+				action = "continue"
+			default:
+				action = "stop"
+			}
+		}
+		switch action {
+		case "retry":
+			ctx.Loops[sp.Name] = 0
+		case "continue":
+			fmt.Printf("%s Leaving loop %s\n\n", dim("○"), sp.Name)
+			ctx.Loops[sp.Name] = 0
+			for _, s := range wf.Stages[i+1 : sp.End+1] {
+				ctx.skip(s.Name)
+			}
+			return sp.End + 1, false, nil
+		case "stop":
+			fmt.Printf("%s Stopping workflow\n", yellow("!"))
+			return i + 1, true, nil
+		case "fail":
+			return 0, false, fmt.Errorf("loop %s: %s not met after %d iterations", sp.Name, sp.Until, n)
+		}
+	} else {
+		fmt.Printf("%s Loop %s: %s not met yet (iteration %d/%d)\n\n", yellow("↻"), sp.Name, sp.Until, n, sp.max())
+	}
+
+	if i == sp.End {
+		return sp.Start, false, nil
+	}
+	return i + 1, false, nil
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -34,7 +36,11 @@ var (
 var currentModel string // Model for current stage
 
 func buildArgs(prompt string) []string {
-	b := config.Backends[current]
+	return buildArgsFor(current, currentModel, prompt)
+}
+
+func buildArgsFor(backend, model, prompt string) []string {
+	b := config.Backends[backend]
 	args := append([]string{}, b.Args...)
 
 	if b.PromptFlag != "" {
@@ -43,11 +49,11 @@ func buildArgs(prompt string) []string {
 		args = append(args, prompt)
 	}
 
-	if currentModel != "" && b.ModelFlag != "" {
-		args = append(args, b.ModelFlag, currentModel)
+	if model != "" && b.ModelFlag != "" {
+		args = append(args, b.ModelFlag, model)
 	}
 
-	if current == "kiro" {
+	if backend == "kiro" {
 		args = append(args, "--no-interactive", "--trust-all-tools")
 	}
 
@@ -55,26 +61,58 @@ func buildArgs(prompt string) []string {
 }
 
 func call(prompt string) string {
-	b := config.Backends[current]
-	args := buildArgs(prompt)
+	result, _ := callBackend(current, currentModel, prompt, os.Stdout)
+	return result
+}
+
+// callBackend runs a backend non-interactively, streaming its output to out.
+// It doesn't touch the session backend, so it is safe to use from several
+// goroutines at once.
+func callBackend(backend, model, prompt string, out io.Writer) (string, error) {
+	result, _, err := callBackendTimeout(backend, model, prompt, out, 0)
+	return result, err
+}
 
-	fmt.Printf("%s %s %s\n", dim("→"), dim(b.Cmd), dim(truncate(strings.Join(args, " "), 80)))
+// callBackendTimeout is callBackend with the process killed after timeout
+// (0 for none); timedOut reports whether that happened.
+func callBackendTimeout(backend, model, prompt string, out io.Writer, timeout time.Duration) (result string, timedOut bool, err error) {
+	b, ok := config.Backends[backend]
+	if !ok {
+		return "", false, fmt.Errorf("unknown backend: %s", backend)
+	}
+	args := buildArgsFor(backend, model, prompt)
+
+	fmt.Fprintf(out, "%s %s %s\n", dim("→"), dim(b.Cmd), dim(truncate(strings.Join(args, " "), 80)))
+
+	runCtx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, timeout)
+		defer cancel()
+	}
 
 	start := time.Now()
-	cmd := exec.Command(b.Cmd, args...)
+	cmd := exec.CommandContext(runCtx, b.Cmd, args...)
+	cmd.WaitDelay = time.Second
 
 	stdout, _ := cmd.StdoutPipe()
 	stderr, _ := cmd.StderrPipe()
-	cmd.Start()
+	if err := cmd.Start(); err != nil {
+		return "", false, err
+	}
 
 	var response strings.Builder
-	go io.Copy(os.Stderr, stderr)
+	errOut := io.Writer(os.Stderr)
+	if out != io.Writer(os.Stdout) {
+		errOut = out
+	}
+	go io.Copy(errOut, stderr)
 
 	buf := make([]byte, 256)
 	for {
 		n, err := stdout.Read(buf)
 		if n > 0 {
-			os.Stdout.Write(buf[:n])
+			out.Write(buf[:n])
 			response.Write(buf[:n])
 		}
 		if err != nil {
@@ -82,22 +120,20 @@ func call(prompt string) string {
 		}
 	}
 
-	cmd.Wait()
+	err = cmd.Wait()
 	elapsed := time.Since(start)
-	fmt.Printf("\n%s\n", dim(fmt.Sprintf("(%s)", elapsed.Round(time.Millisecond))))
+	fmt.Fprintf(out, "\n%s\n", dim(fmt.Sprintf("(%s)", elapsed.Round(time.Millisecond))))
 
-	return strings.TrimSpace(response.String())
+	return strings.TrimSpace(response.String()), runCtx.Err() == context.DeadlineExceeded, err
 }
 
 func callInteractive(prompt string) string {
-	b := config.Backends[current]
+	return callInteractiveBackend(current, currentModel, prompt)
+}
 
-	var args []string
-	if current == "claude" {
-		args = []string{prompt}
-	} else {
-		args = buildArgs(prompt)
-	}
+func callInteractiveBackend(backend, model, prompt string) string {
+	b := config.Backends[backend]
+	args := interactiveArgs(backend, model, prompt)
 
 	fmt.Printf("%s %s %s %s\n", dim("→"), dim(b.Cmd), dim(truncate(strings.Join(args, " "), 60)), yellow("(interactive)"))
 	fmt.Printf("%s Press Ctrl+C when done\n\n", dim("│"))
@@ -111,6 +147,13 @@ func callInteractive(prompt string) string {
 	return "(interactive session completed)"
 }
 
+func interactiveArgs(backend, model, prompt string) []string {
+	if backend == "claude" {
+		return []string{prompt}
+	}
+	return buildArgsFor(backend, model, prompt)
+}
+
 func truncate(s string, n int) string {
 	if len(s) > n {
 		return s[:n] + "..."
@@ -126,18 +169,34 @@ func handleCommand(input string) bool {
 
 	switch parts[0] {
 	case "/switch", "/s":
-		if len(parts) < 2 {
-			fmt.Println("Usage: /switch <backend>")
+		var name string
+		save := false
+		for _, p := range parts[1:] {
+			if p == "--save" {
+				save = true
+			} else if name == "" {
+				name = p
+			}
+		}
+		if name == "" {
+			fmt.Println("Usage: /switch <backend> [--save]")
 			return true
 		}
-		if _, ok := config.Backends[parts[1]]; ok {
-			current = parts[1]
-			config.Default = current
-			saveConfig(config)
-			fmt.Printf("%s Switched to %s\n", green("✓"), config.Backends[current].Name)
-		} else {
-			fmt.Printf("%s Unknown: %s\n", yellow("!"), parts[1])
+		if _, ok := config.Backends[name]; !ok {
+			fmt.Printf("%s Unknown: %s\n", yellow("!"), name)
+			return true
+		}
+		current = name
+		if !save {
+			fmt.Printf("%s Switched to %s %s\n", green("✓"), config.Backends[current].Name, dim("(this session, --save to keep)"))
+			return true
+		}
+		if err := updateConfigFile(map[string]interface{}{"default": name}); err != nil {
+			fmt.Printf("%s Switched to %s but could not save: %v\n", yellow("!"), config.Backends[current].Name, err)
+			return true
 		}
+		config.Default = name
+		fmt.Printf("%s Switched to %s %s\n", green("✓"), config.Backends[current].Name, dim("(saved as default)"))
 		return true
 
 	case "/list", "/l":
@@ -185,11 +244,39 @@ func handleCommand(input string) bool {
 		return true
 
 	case "/resume":
-		var folder string
-		if len(parts) > 1 {
-			folder = parts[1]
+		folder, opts, err := parseResumeArgs(parts[1:])
+		if err == nil {
+			err = resumeWorkflow(folder, opts)
+		}
+		if err != nil {
+			fmt.Printf("%s %v\n", red("Error:"), err)
+		}
+		return true
+
+	case "/fork":
+		if len(parts) < 3 || strings.HasPrefix(parts[1], "-") || strings.HasPrefix(parts[2], "-") {
+			fmt.Println("Usage: /fork <run> <stage> [--backend <name>] [--model <model>] [--edit-prompt | --prompt-file <file>]")
+			return true
+		}
+		folder, opts, err := parseResumeArgs(append([]string{parts[1], "--from", parts[2]}, parts[3:]...))
+		if err == nil {
+			err = forkRun(folder, opts)
 		}
-		if err := resumeWorkflow(folder); err != nil {
+		if err != nil {
+			fmt.Printf("%s %v\n", red("Error:"), err)
+		}
+		return true
+
+	case "/rerun":
+		if len(parts) < 3 || strings.HasPrefix(parts[1], "-") || strings.HasPrefix(parts[2], "-") {
+			fmt.Println("Usage: /rerun <run> <stage> [--backend <name>] [--model <model>] [--edit-prompt | --prompt-file <file>]")
+			return true
+		}
+		folder, opts, err := parseResumeArgs(append([]string{parts[1], "--from", parts[2]}, parts[3:]...))
+		if err == nil {
+			err = resumeWorkflow(folder, opts)
+		}
+		if err != nil {
 			fmt.Printf("%s %v\n", red("Error:"), err)
 		}
 		return true
@@ -200,7 +287,33 @@ func handleCommand(input string) bool {
 			return true
 		}
 		if parts[1] == "history" {
-			showWorkflowHistory()
+			historyCommand(parts[2:])
+			return true
+		}
+		if parts[1] == "lint" {
+			lintWorkflows(parts[2:])
+			return true
+		}
+		if parts[1] == "report" {
+			folder := ""
+			if len(parts) > 2 {
+				folder = parts[2]
+			}
+			md, html, err := writeReport(folder)
+			if err != nil {
+				fmt.Printf("%s %v\n", red("Error:"), err)
+				return true
+			}
+			fmt.Printf("%s Report: %s\n", green("✓"), md)
+			fmt.Printf("%s Report: %s\n", green("✓"), html)
+			return true
+		}
+		if parts[1] == "show" {
+			if len(parts) < 3 {
+				fmt.Println("Usage: /workflow show <name>")
+				return true
+			}
+			showWorkflow(parts[2])
 			return true
 		}
 		wfName := parts[1]
@@ -215,6 +328,12 @@ func handleCommand(input string) bool {
 			listWorkflows()
 			return true
 		}
+		parts, vars := splitVarArgs(parts)
+		params, err := parseVars(vars)
+		if err != nil {
+			fmt.Printf("%s %v\n", red("Error:"), err)
+			return true
+		}
 		var req string
 		if len(parts) > 2 {
 			req = strings.Join(parts[2:], " ")
@@ -224,23 +343,51 @@ func handleCommand(input string) bool {
 			scanner.Scan()
 			req = scanner.Text()
 		}
-		if err := wf.Run(req); err != nil {
+		if _, err := wf.Run(req, params); err != nil {
 			fmt.Printf("%s %v\n", red("Error:"), err)
 		}
 		dryRun = false
 		return true
 
+	case "/which":
+		if len(parts) < 2 {
+			fmt.Println("Usage: /which <name>")
+			return true
+		}
+		whichCommand(parts[1])
+		return true
+
+	case "/reload":
+		if len(parts) > 1 && parts[1] == "watch" {
+			setWatch(watcher == nil)
+			return true
+		}
+		reloadAll()
+		return true
+
 	case "/help", "/?":
 		fmt.Println(cyan("Commands:"))
 		fmt.Println("  /init                - Init project config")
-		fmt.Println("  /switch <name>       - Switch backend")
+		fmt.Println("  /switch <name> [--save] - Switch backend (--save makes it the default)")
 		fmt.Println("  /list                - List backends")
 		fmt.Println("  /workflow <name>     - Run workflow")
-		fmt.Println("  /workflow history    - Show workflow history")
+		fmt.Println("  /workflow history [--workflow w] [--status s] [--since d] [--grep text] - List runs")
+		fmt.Println("  /workflow history show <folder> - Show a run's details")
+		fmt.Println("  /workflow history compare <a> <b> [stage] - Diff two runs' outputs")
+		fmt.Println("  /workflow history prune [--older-than 30d] [--keep 20] - Delete old runs")
+		fmt.Println("  /workflow show <name> - Show merged workflow definition")
+		fmt.Println("  /workflow report [folder] - Write report.md and report.html for a run")
+		fmt.Println("  /workflow lint [name...] - Check workflow definitions for mistakes")
 		fmt.Println("  /workflow --dry-run <name> - Preview workflow")
 		fmt.Println("  /resume [folder]     - Resume workflow (latest or specific)")
+		fmt.Println("  /resume <folder> --from <stage> - Restart a run at a stage")
+		fmt.Println("  /rerun <folder> <stage> [--backend b] [--model m] [--edit-prompt] - Same, with overrides")
+		fmt.Println("  /fork <folder> <stage> [--backend b] [--model m] [--edit-prompt] - Copy a run up to a stage and continue the copy")
 		fmt.Println("  /skills              - List available skills")
 		fmt.Println("  /skill <name>        - Run a skill")
+		fmt.Println("  /which <name>        - Show where a workflow, skill or backend is defined")
+		fmt.Println("  /reload              - Reload config, workflows and skills")
+		fmt.Println("  /reload watch        - Toggle reloading on change (applied when you next press Enter)")
 		fmt.Println("  /clear               - Clear history")
 		fmt.Println("  /config              - Show config path")
 		fmt.Println("  quit                 - Exit")
@@ -260,6 +407,9 @@ func handleCommand(input string) bool {
 func runInteractive() {
 	loadProjectConfig()
 	loadSkills()
+	if flagWatch {
+		setWatch(true)
+	}
 
 	fmt.Println(green("🔀 AI Proxy CLI"))
 	fmt.Printf("Backend: %s %s\n\n", cyan(config.Backends[current].Name), dim("(/? for help)"))
@@ -269,14 +419,24 @@ func runInteractive() {
 	line.SetCtrlCAborts(true)
 
 	// Tab completion
-	commands := []string{"/init", "/switch", "/list", "/workflow", "/resume", "/skills", "/skill", "/clear", "/config", "/help", "quit"}
-	workflows := []string{"feature", "bugfix", "refactor", "api", "test", "docs", "docker", "history", "--dry-run"}
-	backends := []string{"claude", "kiro", "gemini", "cursor"}
+	commands := []string{"/init", "/switch", "/list", "/workflow", "/resume", "/fork", "/rerun", "/history", "/skills", "/skill", "/which", "/reload", "/clear", "/config", "/help", "quit"}
 
 	line.SetCompleter(func(line string) []string {
 		var completions []string
 		line = strings.TrimSpace(line)
 
+		// Names are read on every call so /reload is picked up
+		workflows := []string{"history", "show", "report", "lint", "--dry-run"}
+		for name := range defaultWorkflows {
+			workflows = append(workflows, name)
+		}
+		var backends []string
+		for name := range config.Backends {
+			backends = append(backends, name)
+		}
+		sort.Strings(workflows)
+		sort.Strings(backends)
+
 		// Complete commands
 		if strings.HasPrefix(line, "/") || line == "" {
 			for _, cmd := range commands {
@@ -348,6 +508,9 @@ func runInteractive() {
 			break
 		}
 
+		// Changes seen by /reload watch are applied here, so an empty
+		// line is enough to pick them up
+		applyPendingReload()
 		input = strings.TrimSpace(input)
 		if input == "" {
 			continue
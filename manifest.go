@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestVersion is bumped whenever a field of Manifest changes meaning.
+// Readers refuse manifests newer than they know.
+const manifestVersion = 1
+
+// Manifest is the machine-readable record of a run, kept in manifest.json
+// and rewritten with every checkpoint: what each stage ran, with which
+// backend and arguments, how long it took and how it ended, plus an index
+// of the files in the run directory.
+type Manifest struct {
+	Version     int               `json:"version"`
+	Workflow    string            `json:"workflow"`
+	Name        string            `json:"name"`
+	Requirement string            `json:"requirement"`
+	Params      map[string]string `json:"params,omitempty"`
+	Status      string            `json:"status"`
+	StartedAt   time.Time         `json:"startedAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	Lineage     *Lineage          `json:"lineage,omitempty"`
+	Usage       *Usage            `json:"usage,omitempty"`
+	Stages      []StageRecord     `json:"stages"` // One per execution, in order; loops and re-runs add more
+	Artifacts   []Artifact        `json:"artifacts"`
+}
+
+// StageRecord is one execution of a stage.
+type StageRecord struct {
+	Name       string                 `json:"name"`
+	Attempt    int                    `json:"attempt"`          // 1 for the first execution of the stage in this run
+	Runner     string                 `json:"runner,omitempty"` // Backend, "shell" or "workflow <name>"
+	Status     string                 `json:"status"`           // running, done, failed, stopped or skipped
+	StartedAt  time.Time              `json:"startedAt"`
+	Duration   time.Duration          `json:"duration"`
+	Command    string                 `json:"command,omitempty"` // Shell stages
+	ExitCode   int                    `json:"exitCode,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Output     string                 `json:"output,omitempty"` // Saved output, relative to the run directory
+	OutputHash string                 `json:"outputHash,omitempty"`
+	Verdict    map[string]interface{} `json:"verdict,omitempty"` // Stages with a schema
+	Calls      []CallRecord           `json:"calls,omitempty"`
+}
+
+// CallRecord is one backend call made by a stage.
+type CallRecord struct {
+	Backend     string        `json:"backend"`
+	Model       string        `json:"model,omitempty"`
+	Command     string        `json:"command"`
+	Args        []string      `json:"args"` // The prompt itself is replaced by "<prompt>"
+	PromptHash  string        `json:"promptHash"`
+	Prompt      string        `json:"prompt,omitempty"` // Rendered prompt, saved under prompts/
+	Interactive bool          `json:"interactive,omitempty"`
+	StartedAt   time.Time     `json:"startedAt"`
+	Duration    time.Duration `json:"duration"`
+	ExitCode    int           `json:"exitCode"`
+	TimedOut    bool          `json:"timedOut,omitempty"`
+}
+
+// Artifact is one file in the run directory.
+type Artifact struct {
+	Path   string `json:"path"` // Relative to the run directory
+	Stage  string `json:"stage,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// runManifest collects a run's manifest as it progresses. Copies of the
+// context share it, so parallel branches record into the same one.
+type runManifest struct {
+	mu   sync.Mutex
+	m    Manifest
+	open map[string]int // Stage -> index of its running record
+}
+
+// openManifest continues the manifest in workDir, or starts a new one.
+func openManifest(workDir string) *runManifest {
+	rm := &runManifest{open: make(map[string]int)}
+	if m, err := loadManifest(workDir); err == nil {
+		rm.m = *m
+	}
+	// Stages still running when the process went away
+	for i := range rm.m.Stages {
+		if rm.m.Stages[i].Status == statusRunning {
+			rm.m.Stages[i].Status = nodeFailed
+			rm.m.Stages[i].Error = "interrupted"
+		}
+	}
+	return rm
+}
+
+func loadManifest(workDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(workDir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf("manifest version %d is newer than this build supports (%d)", m.Version, manifestVersion)
+	}
+	return &m, nil
+}
+
+// begin opens the record of a stage execution.
+func (rm *runManifest) begin(stage *Stage) {
+	if rm == nil {
+		return
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rec := StageRecord{Name: stage.Name, Attempt: 1, Runner: stage.runner(), Status: statusRunning, StartedAt: time.Now()}
+	for _, r := range rm.m.Stages {
+		if r.Name == stage.Name && r.Status != nodeSkipped {
+			rec.Attempt++
+		}
+	}
+	if stage.Type == "shell" {
+		rec.Command = stage.Command
+	}
+	rm.m.Stages = append(rm.m.Stages, rec)
+	rm.open[stage.Name] = len(rm.m.Stages) - 1
+}
+
+// end closes the record opened by begin.
+func (rm *runManifest) end(stage *Stage, result string, err error) {
+	if rm == nil {
+		return
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	i, ok := rm.open[stage.Name]
+	if !ok {
+		return
+	}
+	delete(rm.open, stage.Name)
+	rec := &rm.m.Stages[i]
+	rec.Duration = time.Since(rec.StartedAt)
+	switch {
+	case err == nil:
+		rec.Status = nodeDone
+	case errors.Is(err, errStopped):
+		rec.Status = statusStopped
+		rec.Error = err.Error()
+	default:
+		rec.Status = nodeFailed
+		rec.Error = err.Error()
+	}
+	if code := exitCode(err); rec.Command != "" && code > 0 {
+		rec.ExitCode = code
+	}
+	if stage.Schema != nil && result != "" {
+		rec.Verdict, _ = stage.Schema.parse(verdictText(result))
+	}
+}
+
+func (rm *runManifest) call(stage string, c CallRecord) {
+	if rm == nil {
+		return
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if i, ok := rm.open[stage]; ok {
+		rm.m.Stages[i].Calls = append(rm.m.Stages[i].Calls, c)
+	}
+}
+
+func (rm *runManifest) skip(stage string) {
+	if rm == nil {
+		return
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.m.Stages = append(rm.m.Stages, StageRecord{Name: stage, Status: nodeSkipped, StartedAt: time.Now()})
+}
+
+// output notes the saved output of a stage's latest execution.
+func (rm *runManifest) output(stage, path, content string) {
+	if rm == nil {
+		return
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for i := len(rm.m.Stages) - 1; i >= 0; i-- {
+		if rm.m.Stages[i].Name == stage {
+			rm.m.Stages[i].Output = filepath.Base(path)
+			rm.m.Stages[i].OutputHash = hashString(content)
+			return
+		}
+	}
+}
+
+// write saves manifest.json with the run's current state.
+func (rm *runManifest) write(ctx *WorkflowContext, wf *Workflow, status string) {
+	if rm == nil {
+		return
+	}
+	artifacts := indexArtifacts(ctx)
+
+	rm.mu.Lock()
+	m := rm.m
+	m.Version = manifestVersion
+	m.Workflow = wf.Key
+	m.Name = wf.Name
+	m.Requirement = ctx.Requirement
+	m.Params = ctx.Params
+	m.Status = status
+	m.StartedAt = ctx.StartedAt
+	m.UpdatedAt = time.Now()
+	m.Lineage = ctx.Lineage
+	m.Artifacts = artifacts
+	if ctx.Meter != nil {
+		total := ctx.Meter.snapshot().Total
+		m.Usage = &total
+	}
+	data, _ := json.MarshalIndent(m, "", "  ")
+	rm.mu.Unlock()
+
+	writeFileAtomic(filepath.Join(ctx.WorkDir, "manifest.json"), data, 0644)
+}
+
+// indexArtifacts lists the files of the run directory with their hashes,
+// and the stage each saved output belongs to.
+func indexArtifacts(ctx *WorkflowContext) []Artifact {
+	owner := make(map[string]string)
+	for stage, path := range ctx.Outputs {
+		owner[filepath.Base(path)] = stage
+	}
+	artifacts := []Artifact{}
+	filepath.WalkDir(ctx.WorkDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(ctx.WorkDir, path)
+		if rel == "manifest.json" || filepath.Base(rel)[0] == '.' {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		a := Artifact{Path: rel, Size: info.Size(), SHA256: hashFile(path)}
+		if filepath.Dir(rel) == "." {
+			a.Stage = owner[rel]
+		}
+		artifacts = append(artifacts, a)
+		return nil
+	})
+	return artifacts
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	io.Copy(h, f)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// savePrompt keeps a rendered prompt in the run's prompts/ directory, named
+// by its hash, and returns its path relative to the run directory.
+func (ctx *WorkflowContext) savePrompt(prompt string) string {
+	rel := filepath.Join("prompts", hashString(prompt)[:16]+".md")
+	path := filepath.Join(ctx.WorkDir, rel)
+	if _, err := os.Stat(path); err == nil {
+		return rel
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, []byte(prompt), 0644); err != nil {
+		return ""
+	}
+	return rel
+}
+
+// callRecord describes a backend call that started at start and has just
+// returned.
+func callRecord(backend, model, prompt string, interactive bool, start time.Time, timedOut bool, err error) CallRecord {
+	args := buildArgsFor(backend, model, prompt)
+	if interactive {
+		args = interactiveArgs(backend, model, prompt)
+	}
+	return CallRecord{
+		Backend:     backend,
+		Model:       model,
+		Command:     config.Backends[backend].Cmd,
+		Args:        redactArgs(args, prompt),
+		PromptHash:  hashString(prompt),
+		Interactive: interactive,
+		StartedAt:   start,
+		Duration:    time.Since(start),
+		ExitCode:    exitCode(err),
+		TimedOut:    timedOut,
+	}
+}
+
+// redactArgs replaces the prompt in a backend's arguments, which the
+// manifest identifies by hash instead.
+func redactArgs(args []string, prompt string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		if a == prompt {
+			a = "<prompt>"
+		}
+		out[i] = a
+	}
+	return out
+}
+
+// exitCode is the exit status behind a command error: 0 for none, -1 if
+// the command didn't get to exit.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// summary is a short line on duration and calls for history listings.
+func (m *Manifest) summary() string {
+	calls, failed := 0, 0
+	for _, r := range m.Stages {
+		calls += len(r.Calls)
+	}
+	for _, r := range m.lastRecords() {
+		if r.Status == nodeFailed {
+			failed++
+		}
+	}
+	s := fmt.Sprintf("%s, %d calls", m.UpdatedAt.Sub(m.StartedAt).Round(time.Second), calls)
+	if failed > 0 {
+		s += fmt.Sprintf(", %d failed", failed)
+	}
+	return s
+}
+
+// lastRecords returns the latest execution record of each stage.
+func (m *Manifest) lastRecords() map[string]StageRecord {
+	last := make(map[string]StageRecord)
+	for _, r := range m.Stages {
+		last[r.Name] = r
+	}
+	return last
+}
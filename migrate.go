@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Current schema versions. Bump these together with a new entry in the
+// matching migration list whenever the file format changes.
+const (
+	configVersion        = 1
+	projectConfigVersion = 1
+)
+
+// configMigration upgrades a config document from version From to From+1.
+type configMigration struct {
+	From        int
+	Description string
+	Apply       func(doc *configDoc) error
+}
+
+var globalMigrations = []configMigration{
+	{
+		From:        0,
+		Description: "add schema version",
+		Apply:       func(doc *configDoc) error { return nil },
+	},
+}
+
+var projectMigrations = []configMigration{
+	{
+		From:        0,
+		Description: "add schema version",
+		Apply:       func(doc *configDoc) error { return nil },
+	},
+}
+
+func docVersion(doc *configDoc) int {
+	raw, ok := doc.Get("version")
+	if !ok {
+		return 0
+	}
+	var v int
+	json.Unmarshal(raw, &v)
+	return v
+}
+
+// migrateDoc runs every migration needed to bring doc up to latest and
+// returns a description of each step applied.
+func migrateDoc(doc *configDoc, migrations []configMigration, latest int) ([]string, error) {
+	v := docVersion(doc)
+	if v > latest {
+		return nil, fmt.Errorf("written by a newer version of ai-proxy (v%d, this build supports v%d)", v, latest)
+	}
+	var applied []string
+	for v < latest {
+		var step *configMigration
+		for i := range migrations {
+			if migrations[i].From == v {
+				step = &migrations[i]
+				break
+			}
+		}
+		if step == nil {
+			return applied, fmt.Errorf("no migration from v%d", v)
+		}
+		if err := step.Apply(doc); err != nil {
+			return applied, fmt.Errorf("v%d → v%d: %w", v, v+1, err)
+		}
+		v++
+		if _, ok := doc.Get("version"); !ok {
+			// Keep the version at the top of the file where people look for it
+			doc.fields = append([]configField{{Key: "version"}}, doc.fields...)
+		}
+		doc.Set("version", v)
+		applied = append(applied, fmt.Sprintf("v%d → v%d: %s", v-1, v, step.Description))
+	}
+	return applied, nil
+}
+
+// migrateConfigData upgrades raw config bytes in memory.
+func migrateConfigData(data []byte, migrations []configMigration, latest int) ([]byte, []string, error) {
+	doc, err := parseConfigDoc(data)
+	if err != nil {
+		return data, nil, err
+	}
+	applied, err := migrateDoc(doc, migrations, latest)
+	if err != nil || len(applied) == 0 {
+		return data, applied, err
+	}
+	return doc.Bytes(), applied, nil
+}
+
+type migrateOptions struct {
+	DryRun bool
+	Yes    bool
+}
+
+// migrateConfigFiles upgrades the global and project config files on disk,
+// showing what changes and asking before writing.
+func migrateConfigFiles(opts migrateOptions) error {
+	targets := []struct {
+		path       string
+		migrations []configMigration
+		latest     int
+	}{
+		{configPath, globalMigrations, configVersion},
+	}
+	if p := findProjectConfig(); p != "" {
+		targets = append(targets, struct {
+			path       string
+			migrations []configMigration
+			latest     int
+		}{p, projectMigrations, projectConfigVersion})
+	}
+
+	for _, t := range targets {
+		data, err := os.ReadFile(t.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		migrated, applied, err := migrateConfigData(data, t.migrations, t.latest)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.path, err)
+		}
+		if len(applied) == 0 {
+			fmt.Printf("%s %s is up to date (v%d)\n", green("✓"), t.path, t.latest)
+			continue
+		}
+
+		fmt.Printf("%s %s\n", cyan("▶"), t.path)
+		for _, a := range applied {
+			fmt.Printf("%s %s\n", dim("│"), a)
+		}
+		fmt.Println(lineDiff(string(data), string(migrated)))
+
+		if opts.DryRun {
+			continue
+		}
+		if !opts.Yes {
+			fmt.Printf("%s Write changes to %s? [y/N]: ", yellow("?"), t.path)
+			var input string
+			fmt.Scanln(&input)
+			if input != "y" && input != "Y" {
+				fmt.Printf("%s Skipped\n", dim("○"))
+				continue
+			}
+		}
+		err = editConfigFile(t.path, func(doc *configDoc) error {
+			_, err := migrateDoc(doc, t.migrations, t.latest)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.path, err)
+		}
+		fmt.Printf("%s Migrated %s (backup: %s.bak)\n", green("✓"), t.path, t.path)
+	}
+
+	if opts.DryRun {
+		fmt.Printf("%s Dry run, nothing written.\n", yellow("!"))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMigrateDoc(t *testing.T) {
+	chain := []configMigration{
+		{From: 0, Description: "add schema version", Apply: func(doc *configDoc) error { return nil }},
+		{From: 1, Description: "rename cmd", Apply: func(doc *configDoc) error {
+			if raw, ok := doc.Get("cmd"); ok {
+				doc.Delete("cmd")
+				doc.fields = append(doc.fields, configField{Key: "command", Value: raw})
+			}
+			return nil
+		}},
+		{From: 2, Description: "fail", Apply: func(doc *configDoc) error { return fmt.Errorf("boom") }},
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		latest  int
+		want    string // Expected document, "" to skip the check
+		applied int
+		err     string
+	}{
+		{
+			name:    "unversioned gets version first",
+			in:      `{"default": "kiro"}`,
+			latest:  1,
+			want:    "{\n  \"version\": 1,\n  \"default\": \"kiro\"\n}\n",
+			applied: 1,
+		},
+		{
+			name:    "chain keeps key order",
+			in:      `{"b": 1, "cmd": "x", "a": 2}`,
+			latest:  2,
+			want:    "{\n  \"version\": 2,\n  \"b\": 1,\n  \"a\": 2,\n  \"command\": \"x\"\n}\n",
+			applied: 2,
+		},
+		{
+			name:    "current is untouched",
+			in:      `{"version": 2, "cmd": "x"}`,
+			latest:  2,
+			want:    "{\n  \"version\": 2,\n  \"cmd\": \"x\"\n}\n",
+			applied: 0,
+		},
+		{
+			name:   "newer version",
+			in:     `{"version": 5}`,
+			latest: 2,
+			err:    "newer version",
+		},
+		{
+			name:    "failing step",
+			in:      `{"version": 1}`,
+			latest:  3,
+			applied: 1,
+			err:     "v2 → v3: boom",
+		},
+		{
+			name:   "missing step",
+			in:     `{"version": 3}`,
+			latest: 4,
+			err:    "no migration from v3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseConfigDoc([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			applied, err := migrateDoc(doc, chain, tt.latest)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != tt.applied {
+				t.Errorf("applied %q, want %d steps", applied, tt.applied)
+			}
+			if tt.want != "" {
+				if got := string(doc.Bytes()); got != tt.want {
+					t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateConfigDataUnchanged(t *testing.T) {
+	in := []byte(`{"version":1,"default":"claude"}`)
+	out, applied, err := migrateConfigData(in, globalMigrations, configVersion)
+	if err != nil || len(applied) != 0 || string(out) != string(in) {
+		t.Errorf("got %q, %v, %v; want input back unchanged", out, applied, err)
+	}
+}
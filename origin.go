@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const builtinSource = "built-in"
+
+// Origin records where a workflow, skill or backend definition came from.
+type Origin struct {
+	Source  string   // builtinSource, or the file or directory it was loaded from
+	Note    string   // Extra detail, e.g. "extends feature"
+	Shadows []string // Sources of earlier definitions this one replaced
+}
+
+func (o *Origin) String() string {
+	s := o.Source
+	if o.Note != "" {
+		s += " (" + o.Note + ")"
+	}
+	return s
+}
+
+type originTable map[string]*Origin
+
+var (
+	workflowOrigins = originTable{}
+	skillOrigins    = originTable{}
+	backendOrigins  = originTable{}
+)
+
+func init() {
+	for name := range defaultWorkflows {
+		workflowOrigins.set(name, builtinSource, "")
+	}
+}
+
+// set records the origin of name, remembering whatever it replaced.
+func (t originTable) set(name, source, note string) *Origin {
+	o := &Origin{Source: source, Note: note}
+	if prev, ok := t[name]; ok && prev.Source != source {
+		o.Shadows = append([]string{prev.Source}, prev.Shadows...)
+	}
+	t[name] = o
+	return o
+}
+
+// warnShadow tells the user a definition replaced an earlier one of the
+// same name, unless it was deliberately extending it.
+func warnShadow(kind, name string, o *Origin) {
+	if len(o.Shadows) == 0 || strings.HasPrefix(o.Note, "extends ") {
+		return
+	}
+	fmt.Printf("%s %s %s from %s shadows %s\n", yellow("!"), kind, name, o.Source, strings.Join(o.Shadows, ", "))
+}
+
+func printOrigin(o *Origin) {
+	if o == nil {
+		return
+	}
+	fmt.Printf("%s %s\n", dim("Source:"), o)
+	for _, s := range o.Shadows {
+		fmt.Printf("%s %s\n", dim("Shadows:"), s)
+	}
+}
+
+// whichCommand shows every definition named name and where it came from.
+func whichCommand(name string) {
+	found := false
+	if wf, ok := defaultWorkflows[name]; ok {
+		fmt.Printf("%s %s - %s\n", cyan("workflow"), green(name), wf.Name)
+		printOrigin(workflowOrigins[name])
+		found = true
+	}
+	if s, ok := skills[name]; ok {
+		fmt.Printf("%s %s - %s\n", cyan("skill"), green(name), s.Description)
+		printOrigin(skillOrigins[name])
+		found = true
+	}
+	if b, ok := config.Backends[name]; ok {
+		fmt.Printf("%s %s - %s (%s)\n", cyan("backend"), green(name), b.Name, b.Cmd)
+		printOrigin(backendOrigins[name])
+		found = true
+	}
+	if !found {
+		fmt.Printf("%s No workflow, skill or backend named %s\n", yellow("!"), name)
+	}
+}
@@ -1,67 +1,202 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
-	"sync"
+	"time"
 )
 
-func checkCondition(cond string, ctx *WorkflowContext) bool {
-	if cond == "" {
-		return true
+type ParallelResult struct {
+	Index    int
+	Name     string
+	Result   string
+	Extra    map[string]string
+	Err      error
+	Duration time.Duration
+	Log      bytes.Buffer
+}
+
+// parallelGroupEnd returns the index just past the run of adjacent stages
+// sharing stages[start].Parallel.
+func parallelGroupEnd(stages []Stage, start int) int {
+	end := start + 1
+	for end < len(stages) && stages[end].Parallel == stages[start].Parallel {
+		end++
+	}
+	return end
+}
+
+// runParallelGroup runs stages[start:end] concurrently and waits for all of
+// them (fan-in) before results are merged into ctx in stage order. Each
+// branch streams backend output to its own <idx>.<name>.log file. If any
+// branch fails the group fails once every branch has finished, unless a
+// stage in the group sets parallelPolicy "continue", in which case failed
+// branches are reported and skipped.
+func runParallelGroup(wf *Workflow, ctx *WorkflowContext, start, end int) error {
+	group := wf.Stages[start].Parallel
+	policy := "fail"
+	for _, s := range wf.Stages[start:end] {
+		if s.ParallelPolicy != "" {
+			policy = s.ParallelPolicy
+		}
 	}
-	switch {
-	case strings.HasPrefix(cond, "file:"):
-		file := strings.TrimPrefix(cond, "file:")
-		_, err := os.Stat(file)
-		return err == nil
-	case strings.HasPrefix(cond, "!file:"):
-		file := strings.TrimPrefix(cond, "!file:")
-		_, err := os.Stat(file)
-		return err != nil
-	case strings.HasPrefix(cond, "has:"):
-		ext := strings.TrimPrefix(cond, "has:")
-		found := false
-		filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
-			if strings.HasSuffix(path, ext) {
-				found = true
+
+	// Conditions and skip prompts are settled up front, one at a time
+	var branches, interactive []int
+	for k := start; k < end; k++ {
+		s := wf.Stages[k]
+		if s.Condition != "" && !checkCondition(s.Condition, ctx) {
+			fmt.Printf("%s [Stage %d/%d] %s - %s\n", dim("○"), k+1, len(wf.Stages), s.Name, dim("skipped (condition not met)"))
+			ctx.skip(s.Name)
+			continue
+		}
+		if s.Skippable {
+			if askSkip(fmt.Sprintf("%s Skip stage %s? [y/N]: ", yellow("?"), s.Name)) {
+				fmt.Printf("%s Skipped\n", dim("○"))
+				ctx.skip(s.Name)
+				continue
 			}
-			return nil
-		})
-		return found
-	case cond == "go":
-		_, err := os.Stat("go.mod")
-		return err == nil
-	case cond == "node":
-		_, err := os.Stat("package.json")
-		return err == nil
-	case cond == "docker":
-		_, err := os.Stat("Dockerfile")
-		return err == nil
+		}
+		// Interactive stages need the terminal, so they run after the others
+		if s.Interactive {
+			interactive = append(interactive, k)
+		} else {
+			branches = append(branches, k)
+		}
 	}
-	return true
-}
 
-type ParallelResult struct {
-	Name   string
-	Result string
-	Err    error
+	var names []string
+	for _, k := range branches {
+		names = append(names, fmt.Sprintf("%s (%s)", wf.Stages[k].Name, wf.Stages[k].runner()))
+	}
+	fmt.Printf("%s [Stages %d-%d/%d] parallel group %s: %s\n", cyan("⇉"), start+1, end, len(wf.Stages), group, strings.Join(names, ", "))
+
+	results := make([]*ParallelResult, end-start)
+	done := make(chan *ParallelResult)
+	for _, k := range branches {
+		go func(branch *WorkflowContext, idx int, s Stage) {
+			done <- runBranch(branch, idx, s, true)
+		}(branchContext(ctx), k, wf.Stages[k])
+	}
+
+	for n := range branches {
+		r := <-done
+		results[r.Index-start] = r
+		bar := progressBar(n+1, len(branches), 10)
+		if r.Err != nil {
+			fmt.Printf("%s %s %s failed: %v\n", dim(bar), red("✗"), r.Name, r.Err)
+		} else {
+			fmt.Printf("%s %s %s (%s)\n", dim(bar), green("✓"), r.Name, formatDuration(r.Duration))
+		}
+	}
+
+	for _, k := range interactive {
+		s := wf.Stages[k]
+		fmt.Printf("%s [Stage %d/%d] %s (%s)\n", cyan("●"), k+1, len(wf.Stages), s.Name, s.runner())
+		results[k-start] = runBranch(branchContext(ctx), k, s, false)
+	}
+
+	// Fan-in in stage order so results and the log read the same every run
+	var failed []string
+	var stopErr error
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		if !mergeBranch(ctx, wf, r, "parallel group "+group) {
+			failed = append(failed, r.Name)
+			if errors.Is(r.Err, errStopped) {
+				stopErr = r.Err
+			}
+		}
+	}
+	ctx.Timer.StageComplete()
+
+	if stopErr != nil {
+		return fmt.Errorf("parallel group %s: %w", group, stopErr)
+	}
+	if len(failed) > 0 {
+		if policy != "continue" {
+			return fmt.Errorf("parallel group %s failed: %s", group, strings.Join(failed, ", "))
+		}
+		fmt.Printf("%s Continuing without: %s\n", yellow("!"), strings.Join(failed, ", "))
+		for _, name := range failed {
+			ctx.skip(name)
+		}
+	}
+	fmt.Printf("%s Parallel group %s completed\n\n", green("✓"), group)
+	return nil
 }
 
-func runParallelStages(stages []Stage, ctx *WorkflowContext) []ParallelResult {
-	var wg sync.WaitGroup
-	results := make([]ParallelResult, len(stages))
+// branchContext copies ctx for a branch. Other branches may finish and
+// merge into ctx while this one runs, so the copy gets its own maps and
+// slices. It must be called on the goroutine that owns ctx, before the
+// branch starts.
+func branchContext(ctx *WorkflowContext) *WorkflowContext {
+	branch := *ctx
+	branch.Results = maps.Clone(ctx.Results)
+	branch.Outputs = maps.Clone(ctx.Outputs)
+	branch.Nodes = maps.Clone(ctx.Nodes)
+	branch.Loops = maps.Clone(ctx.Loops)
+	branch.Params = maps.Clone(ctx.Params)
+	branch.Skipped = slices.Clone(ctx.Skipped)
+	branch.Gates = slices.Clone(ctx.Gates)
+	branch.Revisions = slices.Clone(ctx.Revisions)
+	branch.Stack = slices.Clone(ctx.Stack)
+	return &branch
+}
 
-	for i, stage := range stages {
-		wg.Add(1)
-		go func(idx int, s Stage) {
-			defer wg.Done()
-			result, err := runStage(&s, ctx)
-			results[idx] = ParallelResult{Name: s.Name, Result: result, Err: err}
-		}(i, stage)
+// runBranch runs one stage on a branch context from branchContext, logging
+// into the result instead of log.md. With toFile set, backend output goes
+// to <idx>.<name>.log rather than the terminal.
+func runBranch(branch *WorkflowContext, idx int, s Stage, toFile bool) *ParallelResult {
+	r := &ParallelResult{Index: idx, Name: s.Name}
+	branch.LogFile = &r.Log
+	if toFile {
+		logPath := filepath.Join(branch.WorkDir, fmt.Sprintf("%d.%s.log", idx, s.Name))
+		out, err := os.Create(logPath)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		defer out.Close()
+		branch.Output = out
 	}
+	started := time.Now()
+	r.Result, r.Extra, r.Err = execStage(&s, branch)
+	r.Duration = time.Since(started)
+	return r
+}
 
-	wg.Wait()
-	return results
+// mergeBranch copies a finished branch's log, results and output file into
+// ctx, and reports whether the branch succeeded. Must be called from the
+// goroutine that owns ctx.
+func mergeBranch(ctx *WorkflowContext, wf *Workflow, r *ParallelResult, label string) bool {
+	s := wf.Stages[r.Index]
+	ctx.log("## Stage %d: %s (%s)\n\n", r.Index+1, s.Name, label)
+	ctx.log("%s", r.Log.String())
+	if r.Err != nil {
+		ctx.log("### Error\n```\n%v\n```\n\n", r.Err)
+		return false
+	}
+	for k, v := range r.Extra {
+		ctx.Results[k] = v
+	}
+	ctx.Results[s.Name] = r.Result
+	saveStageOutput(ctx, r.Index, &s, r.Result)
+	ctx.log("### Output\n```\n%s\n```\n\n", truncate(r.Result, 2000))
+	if s.Gate {
+		if err := gateStage(ctx, r.Index, &s); err != nil {
+			r.Err = err
+			ctx.log("### Error\n```\n%v\n```\n\n", err)
+			return false
+		}
+	}
+	return true
 }
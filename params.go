@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Param is a named input a workflow takes in addition to the requirement.
+type Param struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type,omitempty"` // string (default), number or boolean
+	Default     string   `json:"default,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+}
+
+var paramNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// normalize checks a value against the parameter's type and enum and
+// returns it in canonical form.
+func (p *Param) normalize(value string) (string, error) {
+	switch p.Type {
+	case "", "string":
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("%s: %q is not a number", p.Name, value)
+		}
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %q is not true or false", p.Name, value)
+		}
+		value = strconv.FormatBool(b)
+	}
+	if len(p.Enum) > 0 {
+		for _, e := range p.Enum {
+			if strings.EqualFold(value, e) {
+				return e, nil
+			}
+		}
+		return "", fmt.Errorf("%s: %q is not one of %s", p.Name, value, strings.Join(p.Enum, ", "))
+	}
+	return value, nil
+}
+
+// checkParams validates the declarations themselves.
+func (wf *Workflow) checkParams() error {
+	seen := make(map[string]bool)
+	for i := range wf.Params {
+		p := &wf.Params[i]
+		if !paramNameRe.MatchString(p.Name) {
+			return fmt.Errorf("param %q: names must be letters, digits and _", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate param %s", p.Name)
+		}
+		seen[p.Name] = true
+		switch p.Type {
+		case "", "string", "number", "boolean":
+		default:
+			return fmt.Errorf("param %s: unknown type %q", p.Name, p.Type)
+		}
+		if p.Default != "" {
+			if _, err := p.normalize(p.Default); err != nil {
+				return fmt.Errorf("param default: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (wf *Workflow) param(name string) *Param {
+	for i := range wf.Params {
+		if wf.Params[i].Name == name {
+			return &wf.Params[i]
+		}
+	}
+	return nil
+}
+
+// resolveParams validates the given values, fills in defaults and, if ask
+// is set, prompts for anything still missing. Every declared parameter is
+// present in the result, so prompts can reference optional ones.
+func (wf *Workflow) resolveParams(given map[string]string, ask bool) (map[string]string, error) {
+	for name := range given {
+		if wf.param(name) == nil {
+			return nil, fmt.Errorf("workflow %s has no parameter %s", wf.Key, name)
+		}
+	}
+	values := make(map[string]string, len(wf.Params))
+	for i := range wf.Params {
+		p := &wf.Params[i]
+		value, ok := given[p.Name]
+		for !ok && ask && !stdinClosed {
+			value = promptParam(p)
+			ok = value != "" || p.Default != "" || !p.Required
+		}
+		if !ok || value == "" {
+			value = p.Default
+		}
+		if value == "" {
+			if p.Required {
+				return nil, fmt.Errorf("missing required parameter %s", p.Name)
+			}
+			values[p.Name] = ""
+			continue
+		}
+		norm, err := p.normalize(value)
+		if err != nil {
+			return nil, err
+		}
+		values[p.Name] = norm
+	}
+	return values, nil
+}
+
+// promptParam asks for one parameter; an empty answer means the default.
+func promptParam(p *Param) string {
+	label := p.Name
+	if p.Description != "" {
+		label += dim(" (" + p.Description + ")")
+	}
+	if len(p.Enum) > 0 {
+		label += " [" + strings.Join(p.Enum, "/") + "]"
+	}
+	if p.Default != "" {
+		label += dim(" default: " + p.Default)
+	}
+	fmt.Printf("%s %s: ", yellow("?"), label)
+	return strings.TrimSpace(readLine())
+}
+
+// parseVars turns repeated key=value flags into a map.
+func parseVars(vars []string) (map[string]string, error) {
+	out := make(map[string]string, len(vars))
+	for _, v := range vars {
+		k, val, ok := strings.Cut(v, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("--var %q: expected key=value", v)
+		}
+		out[k] = val
+	}
+	return out, nil
+}
+
+// readParamsFile reads parameter values from a JSON or YAML object. Values
+// given with --var take precedence.
+func readParamsFile(path string, into map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for k, v := range raw {
+		if _, set := into[k]; !set {
+			into[k] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// splitVarArgs pulls "--var key=value" pairs out of a REPL command line.
+func splitVarArgs(parts []string) (rest []string, vars []string) {
+	for i := 0; i < len(parts); i++ {
+		if parts[i] == "--var" && i+1 < len(parts) {
+			vars = append(vars, parts[i+1])
+			i++
+			continue
+		}
+		if strings.HasPrefix(parts[i], "--var=") {
+			vars = append(vars, strings.TrimPrefix(parts[i], "--var="))
+			continue
+		}
+		rest = append(rest, parts[i])
+	}
+	return rest, vars
+}
+
+func formatParams(params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, k := range names {
+		parts = append(parts, k+"="+params[k])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParamNormalize(t *testing.T) {
+	tests := []struct {
+		param Param
+		value string
+		want  string
+		err   string
+	}{
+		{Param{Name: "s"}, "anything", "anything", ""},
+		{Param{Name: "n", Type: "number"}, "80", "80", ""},
+		{Param{Name: "n", Type: "number"}, "1.5", "1.5", ""},
+		{Param{Name: "n", Type: "number"}, "lots", "", `n: "lots" is not a number`},
+		{Param{Name: "b", Type: "boolean"}, "TRUE", "true", ""},
+		{Param{Name: "b", Type: "boolean"}, "0", "false", ""},
+		{Param{Name: "b", Type: "boolean"}, "yes", "", `b: "yes" is not true or false`},
+		{Param{Name: "e", Enum: []string{"gin", "echo"}}, "ECHO", "echo", ""},
+		{Param{Name: "e", Enum: []string{"gin", "echo"}}, "chi", "", `e: "chi" is not one of gin, echo`},
+	}
+	for _, tt := range tests {
+		got, err := tt.param.normalize(tt.value)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s(%q): err = %v, want %q", tt.param.Name, tt.value, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s(%q) = %q, %v; want %q", tt.param.Name, tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []Param
+		err    string
+	}{
+		{"valid", []Param{{Name: "lang"}, {Name: "max_retries", Type: "number", Default: "3"}}, ""},
+		{"bad name", []Param{{Name: "my-param"}}, `param "my-param": names must be letters, digits and _`},
+		{"leading digit", []Param{{Name: "1x"}}, "names must be letters"},
+		{"duplicate", []Param{{Name: "a"}, {Name: "a"}}, "duplicate param a"},
+		{"unknown type", []Param{{Name: "a", Type: "date"}}, `param a: unknown type "date"`},
+		{"bad default", []Param{{Name: "a", Type: "boolean", Default: "maybe"}}, "param default"},
+		{"default not in enum", []Param{{Name: "a", Enum: []string{"x"}, Default: "y"}}, "is not one of x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := &Workflow{Params: tt.params}
+			err := wf.checkParams()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	wf := &Workflow{Key: "api", Params: []Param{
+		{Name: "framework", Enum: []string{"gin", "echo"}, Required: true},
+		{Name: "auth", Type: "boolean", Default: "true"},
+		{Name: "note"},
+	}}
+	tests := []struct {
+		name  string
+		given map[string]string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "defaults fill in",
+			given: map[string]string{"framework": "Gin"},
+			want:  map[string]string{"framework": "gin", "auth": "true", "note": ""},
+		},
+		{
+			name:  "given values win",
+			given: map[string]string{"framework": "echo", "auth": "F", "note": "hi"},
+			want:  map[string]string{"framework": "echo", "auth": "false", "note": "hi"},
+		},
+		{
+			name:  "empty value means default",
+			given: map[string]string{"framework": "gin", "auth": ""},
+			want:  map[string]string{"framework": "gin", "auth": "true", "note": ""},
+		},
+		{
+			name:  "resolved values resolve to themselves",
+			given: map[string]string{"framework": "gin", "auth": "true", "note": ""},
+			want:  map[string]string{"framework": "gin", "auth": "true", "note": ""},
+		},
+		{name: "missing required", given: map[string]string{}, err: "missing required parameter framework"},
+		{name: "unknown param", given: map[string]string{"framework": "gin", "port": "80"}, err: "workflow api has no parameter port"},
+		{name: "bad value", given: map[string]string{"framework": "chi"}, err: `framework: "chi" is not one of gin, echo`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wf.resolveParams(tt.given, false)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	got, err := parseVars([]string{"a=1", "b=x=y", "c="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a": "1", "b": "x=y", "c": ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{"novalue", "=1"} {
+		if _, err := parseVars([]string{bad}); err == nil {
+			t.Errorf("parseVars(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestReadParamsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.yaml")
+	if err := os.WriteFile(path, []byte("framework: gin\ncoverage: 80\nauth: false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// --var values given on the command line take precedence
+	params := map[string]string{"framework": "echo"}
+	if err := readParamsFile(path, params); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"framework": "echo", "coverage": "80", "auth": "false"}; !reflect.DeepEqual(params, want) {
+		t.Errorf("got %v, want %v", params, want)
+	}
+}
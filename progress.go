@@ -50,3 +50,9 @@ func (t *StageTimer) EstimateRemaining(currentStage, totalStages int) string {
 	remaining := avgPerStage * time.Duration(totalStages-currentStage)
 	return formatDuration(remaining)
 }
+
+// restoreStageTimer continues timing a resumed run, keeping the durations of
+// stages completed before it was interrupted.
+func restoreStageTimer(stages []time.Duration) *StageTimer {
+	return &StageTimer{StartTime: time.Now(), Stages: stages}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// StageOutput is what a prompt can reference about an earlier stage.
+type StageOutput struct {
+	Output  string                 // Stage result, ANSI codes stripped
+	File    string                 // Saved output file, empty if the stage has no outputFile
+	Verdict map[string]interface{} // Parsed verdict for stages with a schema
+}
+
+// stageOutputs returns every result recorded so far, keyed by stage name.
+// Stages of the running workflow that haven't produced anything yet are
+// present but empty, so only misspelled names fail to render. Non-stage
+// results such as "diff" and "verify" are included too.
+func (ctx *WorkflowContext) stageOutputs() map[string]StageOutput {
+	outs := make(map[string]StageOutput, len(ctx.Results))
+	if ctx.Workflow != nil {
+		for _, s := range ctx.Workflow.Stages {
+			outs[s.Name] = StageOutput{}
+		}
+	}
+	for name, result := range ctx.Results {
+		outs[name] = StageOutput{Output: stripANSI(result), File: ctx.Outputs[name]}
+	}
+	// Every schema field is present, so a verdict that isn't there yet
+	// renders empty instead of failing
+	if ctx.Workflow != nil {
+		for _, s := range ctx.Workflow.Stages {
+			if s.Schema == nil {
+				continue
+			}
+			out := outs[s.Name]
+			out.Verdict = make(map[string]interface{}, len(s.Schema.Fields))
+			for field := range s.Schema.Fields {
+				out.Verdict[field] = nil
+			}
+			for field, val := range ctx.verdict(s.Name) {
+				out.Verdict[field] = val
+			}
+			outs[s.Name] = out
+		}
+	}
+	return outs
+}
+
+// legacyPromptVars are the fixed placeholders from before stage references
+// existed. They are kept as aliases and filled from the well-known output
+// file names of the built-in workflows.
+func legacyPromptVars(ctx *WorkflowContext) map[string]interface{} {
+	firstOutput := func(names ...string) string {
+		for _, name := range names {
+			if content := findOutputFile(ctx.WorkDir, name); len(content) > 0 {
+				return string(content)
+			}
+		}
+		return ""
+	}
+	return map[string]interface{}{
+		"Requirement":     ctx.Requirement,
+		"ProjectContext":  ctx.Results["project-context"],
+		"PlanContent":     firstOutput("plan.md", "analysis.md", "api-plan.md", "refactor-plan.md"),
+		"TasksContent":    firstOutput("tasks.md", "fix-tasks.md", "refactor-tasks.md"),
+		"ReviewContent":   firstOutput("review.md"),
+		"VerifyContent":   firstOutput("verify.md"),
+		"DiffContent":     firstOutput("diff.md"),
+		"SecurityContent": firstOutput("security.md"),
+	}
+}
+
+// promptData is the template data for stage prompts.
+func promptData(ctx *WorkflowContext) map[string]interface{} {
+	data := legacyPromptVars(ctx)
+	data["Stages"] = ctx.stageOutputs()
+	params := ctx.Params
+	if params == nil {
+		params = map[string]string{}
+	}
+	data["Params"] = params
+	return data
+}
+
+// stageSource names a stage prompt in template errors: where the workflow
+// was defined, then which workflow and stage.
+func stageSource(ctx *WorkflowContext, stage *Stage) string {
+	key := ""
+	if ctx.Workflow != nil {
+		key = ctx.Workflow.Key
+	}
+	source := builtinSource
+	if o := workflowOrigins[key]; o != nil {
+		source = o.Source
+	}
+	return fmt.Sprintf("%s (workflow %s, stage %s)", source, key, stage.Name)
+}
+
+// Stage names may contain dashes, which template field syntax doesn't
+// allow, so .Stages.code-review is rewritten to (index .Stages "code-review").
+var dashedStageRef = regexp.MustCompile(`\.Stages\.([\w]+(?:-[\w]+)+)`)
+
+// renderTemplate renders a prompt with text/template. Referencing a variable
+// that doesn't exist is an error rather than an empty string, and errors
+// carry the source name and line.
+func renderTemplate(source, text string, data interface{}) (string, error) {
+	text = dashedStageRef.ReplaceAllString(text, `(index .Stages "$1")`)
+	t, err := template.New(source).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// maxTemplateFile caps how much the file function will read into a prompt.
+const maxTemplateFile = 256 * 1024
+
+var templateFuncs = template.FuncMap{
+	// {{.DiffContent | truncate 2000}}
+	"truncate": func(n int, s string) string { return truncate(s, n) },
+	// {{file "go.mod"}} - contents of a file inside the project
+	"file": func(path string) (string, error) {
+		if err := checkProjectPath(path); err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if len(data) > maxTemplateFile {
+			data = data[:maxTemplateFile]
+		}
+		return string(data), nil
+	},
+	// {{range glob "*.go"}}...{{end}}
+	"glob": func(pattern string) ([]string, error) {
+		if err := checkProjectPath(pattern); err != nil {
+			return nil, err
+		}
+		return filepath.Glob(pattern)
+	},
+	"json": func(v interface{}) (string, error) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		return string(data), err
+	},
+	// {{.focus | default "everything"}}
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	// {{.PlanContent | indent 4}}
+	"indent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+}
+
+// checkProjectPath keeps template file access inside the working directory.
+func checkProjectPath(path string) error {
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s: only paths inside the project are allowed", path)
+	}
+	return nil
+}
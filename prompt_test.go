@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := map[string]interface{}{
+		"Requirement": "add login",
+		"Params":      map[string]string{"lang": "go", "empty": ""},
+		"Stages": map[string]StageOutput{
+			"plan":        {Output: "the plan", File: ".workflow/x/0.plan.md"},
+			"code-review": {Output: "looks good", Verdict: map[string]interface{}{"status": "APPROVED"}},
+		},
+	}
+	tests := []struct {
+		name string
+		text string
+		want string
+		err  string
+	}{
+		{"plain", "do {{.Requirement}}", "do add login", ""},
+		{"param", "in {{.Params.lang}}", "in go", ""},
+		{"stage output", "{{.Stages.plan.Output}} in {{.Stages.plan.File}}", "the plan in .workflow/x/0.plan.md", ""},
+		{"dashed stage name", "{{.Stages.code-review.Output}}", "looks good", ""},
+		{"dashed verdict", "{{.Stages.code-review.Verdict.status}}", "APPROVED", ""},
+		{"truncate", "{{.Requirement | truncate 3}}", "add...", ""},
+		{"default", `{{.Params.empty | default "all"}}`, "all", ""},
+		{"indent", "{{\"a\\nb\" | indent 2}}", "  a\n  b", ""},
+		{"json", `{{json .Params.lang}}`, `"go"`, ""},
+		{"conditional", "{{if .Stages.plan.Output}}yes{{else}}no{{end}}", "yes", ""},
+		{"misspelled variable", "{{.Requirment}}", "", "Requirment"},
+		{"missing param", "{{.Params.nope}}", "", "nope"},
+		{"syntax error names the source", "{{.Requirement", "", "test.md"},
+		{"file outside the project", `{{file "../secret"}}`, "", "only paths inside the project"},
+		{"absolute glob", `{{glob "/etc/*"}}`, "", "only paths inside the project"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate("test.md", tt.text, data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want it to mention %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckProjectPath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"go.mod", true},
+		{"cmd/main.go", true},
+		{"./docs/../README.md", true},
+		{"..foo/bar", true},
+		{"*.go", true},
+		{"..", false},
+		{"../x", false},
+		{"a/../../x", false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		if err := checkProjectPath(tt.path); (err == nil) != tt.ok {
+			t.Errorf("checkProjectPath(%q) = %v, want ok=%v", tt.path, err, tt.ok)
+		}
+	}
+}
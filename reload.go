@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// builtinWorkflows is the pristine set of workflows, before any project
+// config was merged into defaultWorkflows.
+var builtinWorkflows = map[string]Workflow{}
+
+func init() {
+	for name, wf := range defaultWorkflows {
+		builtinWorkflows[name] = wf
+	}
+}
+
+// reloadAll re-reads the global config, project config and skill directories
+// and reports what changed. Chat history and the session backend are kept.
+func reloadAll() {
+	oldWorkflows := defaultWorkflows
+	oldSkills := skills
+	oldBackends := config.Backends
+
+	backendOrigins = originTable{}
+	workflowOrigins = originTable{}
+	skillOrigins = originTable{}
+
+	config = loadConfig()
+	defaultWorkflows = make(map[string]Workflow, len(builtinWorkflows))
+	for name, wf := range builtinWorkflows {
+		defaultWorkflows[name] = wf
+		workflowOrigins.set(name, builtinSource, "")
+	}
+	loadProjectConfig()
+	skills = make(map[string]*Skill)
+	loadSkills()
+
+	changed := false
+	changed = reportChanges("backend", oldBackends, config.Backends) || changed
+	changed = reportChanges("workflow", oldWorkflows, defaultWorkflows) || changed
+	changed = reportChanges("skill", oldSkills, skills) || changed
+	if !changed {
+		fmt.Printf("%s Reloaded, no changes\n", green("✓"))
+	}
+
+	if _, ok := config.Backends[current]; !ok {
+		fmt.Printf("%s Backend %s no longer exists, switching to %s\n", yellow("!"), current, config.Default)
+		current = config.Default
+	}
+}
+
+// reportChanges prints added, removed and modified entries between two
+// versions of a definition map and reports whether anything differed.
+func reportChanges[V any](kind string, before, after map[string]V) bool {
+	var lines []string
+	for name, v := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("%s %s %s added", green("+"), kind, name))
+		case !reflect.DeepEqual(old, v):
+			lines = append(lines, fmt.Sprintf("%s %s %s changed", yellow("~"), kind, name))
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			lines = append(lines, fmt.Sprintf("%s %s %s removed", red("-"), kind, name))
+		}
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	return len(lines) > 0
+}
+
+// watchPaths lists the files whose changes trigger a reload.
+func watchPaths() []string {
+	paths := []string{configPath}
+	if p := findProjectConfig(); p != "" {
+		paths = append(paths, p)
+	}
+	for _, dir := range []string{getSkillsDir(), filepath.Join(".ai-proxy", "skills")} {
+		paths = append(paths, dir)
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if e.IsDir() {
+				paths = append(paths,
+					filepath.Join(dir, e.Name(), "skill.yaml"),
+					filepath.Join(dir, e.Name(), "prompt.md"))
+			}
+		}
+	}
+	return paths
+}
+
+func watchFingerprint() string {
+	var b strings.Builder
+	for _, p := range watchPaths() {
+		if info, err := os.Stat(p); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d\n", p, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return b.String()
+}
+
+// configWatcher polls the config and skill files and signals on Changed when
+// any of them is modified. The REPL applies the reload between commands so
+// it never races with a running workflow.
+type configWatcher struct {
+	Changed chan struct{}
+	stop    chan struct{}
+}
+
+func startConfigWatcher(interval time.Duration) *configWatcher {
+	w := &configWatcher{
+		Changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	go func() {
+		last := watchFingerprint()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				if fp := watchFingerprint(); fp != last {
+					last = fp
+					select {
+					case w.Changed <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return w
+}
+
+func (w *configWatcher) Stop() {
+	close(w.stop)
+}
+
+var watcher *configWatcher
+
+func setWatch(on bool) {
+	switch {
+	case on && watcher == nil:
+		watcher = startConfigWatcher(2 * time.Second)
+		fmt.Printf("%s Watching config and skills for changes %s\n", green("✓"), dim("(applied when you next press Enter)"))
+	case !on && watcher != nil:
+		watcher.Stop()
+		watcher = nil
+		fmt.Printf("%s Stopped watching\n", green("✓"))
+	}
+}
+
+// applyPendingReload reloads if the watcher saw a change since the last call.
+func applyPendingReload() {
+	if watcher == nil {
+		return
+	}
+	select {
+	case <-watcher.Changed:
+		fmt.Printf("%s Config changed, reloading\n", dim("●"))
+		reloadAll()
+	default:
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runReport is what a run report shows, gathered from the run directory.
+type runReport struct {
+	Run         string
+	Workflow    string
+	Name        string
+	Status      string
+	Requirement string
+	Params      map[string]string
+	StartedAt   time.Time
+	Duration    time.Duration
+	Lineage     *Lineage
+	Usage       *RunUsage
+	Budget      string
+	Costs       []reportCost // Per stage, in workflow order
+	Timeline    []reportEntry
+	Stages      []reportStage
+	Loops       []reportLoop
+	Gates       []GateDecision
+	Revisions   []Revision
+	Diff        string
+	Verify      string
+}
+
+// reportEntry is one stage execution on the timeline.
+type reportEntry struct {
+	StageRecord
+	Offset  time.Duration // From the start of the run
+	Runner  string        // Stage runner, and the backend actually called if different
+	Verdict string
+}
+
+type reportStage struct {
+	Name    string
+	Runner  string
+	Status  string
+	Prompt  string
+	Output  string
+	Verdict string
+	Usage   *Usage
+}
+
+type reportCost struct {
+	Name string
+	Usage
+}
+
+type reportLoop struct {
+	Name       string
+	Until      string
+	Iterations int
+	Entries    []reportEntry
+}
+
+// writeReport renders the report of a run as report.md and report.html in
+// its directory, and returns their paths.
+func writeReport(folder string) (string, string, error) {
+	dir, err := findRunDir(folder)
+	if err != nil {
+		return "", "", err
+	}
+	r, err := loadReport(dir)
+	if err != nil {
+		return "", "", err
+	}
+	mdPath := filepath.Join(dir, "report.md")
+	if err := os.WriteFile(mdPath, []byte(r.markdown()), 0644); err != nil {
+		return "", "", err
+	}
+	var html strings.Builder
+	if err := reportTemplate.Execute(&html, r); err != nil {
+		return "", "", err
+	}
+	htmlPath := filepath.Join(dir, "report.html")
+	if err := os.WriteFile(htmlPath, []byte(html.String()), 0644); err != nil {
+		return "", "", err
+	}
+	return mdPath, htmlPath, nil
+}
+
+func loadReport(dir string) (*runReport, error) {
+	state, err := loadCheckpoint(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load checkpoint: %w", err)
+	}
+	wf := state.Definition
+	if wf == nil {
+		wf = getWorkflow(state.WorkflowName)
+	}
+	if wf == nil {
+		return nil, fmt.Errorf("unknown workflow: %s", state.WorkflowName)
+	}
+	m, err := loadManifest(dir)
+	if err != nil {
+		m = &Manifest{UpdatedAt: state.StartedAt}
+	}
+	run := dir
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		run = resolved
+	}
+
+	r := &runReport{
+		Run:         filepath.Base(run),
+		Workflow:    state.WorkflowName,
+		Name:        wf.Name,
+		Status:      state.Status,
+		Requirement: state.Requirement,
+		Params:      state.Params,
+		StartedAt:   state.StartedAt,
+		Duration:    m.UpdatedAt.Sub(state.StartedAt),
+		Lineage:     state.Lineage,
+		Usage:       state.Usage,
+		Gates:       state.Gates,
+		Revisions:   state.Revisions,
+	}
+	if r.Duration < 0 {
+		r.Duration = 0
+	}
+	if wf.Budget != nil {
+		r.Budget = wf.Budget.String()
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "diff.md")); err == nil {
+		r.Diff = string(data)
+	}
+	r.Verify = state.Results["verify"]
+	if state.Usage != nil {
+		r.Costs = costs(wf, state.Usage)
+	}
+
+	for _, rec := range m.Stages {
+		r.Timeline = append(r.Timeline, newReportEntry(rec, state.StartedAt))
+	}
+
+	last := m.lastRecords()
+	skipped := make(map[string]bool)
+	for _, s := range state.Skipped {
+		skipped[s] = true
+	}
+	for _, s := range wf.Stages {
+		st := reportStage{Name: s.Name, Runner: s.runner(), Status: "pending"}
+		rec, ok := last[s.Name]
+		switch {
+		case ok:
+			st.Status = rec.Status
+		case skipped[s.Name]:
+			st.Status = nodeSkipped
+		case hasResult(state.Results, s.Name):
+			st.Status = nodeDone
+		}
+		if ok && len(rec.Calls) > 0 && rec.Calls[0].Prompt != "" {
+			if data, err := os.ReadFile(filepath.Join(dir, rec.Calls[0].Prompt)); err == nil {
+				st.Prompt = string(data)
+			}
+		}
+		if path, ok := state.Outputs[s.Name]; ok {
+			if data, err := os.ReadFile(filepath.Join(dir, filepath.Base(path))); err == nil {
+				st.Output = string(data)
+			}
+		}
+		if st.Output == "" {
+			st.Output = stripANSI(state.Results[s.Name])
+		}
+		if ok {
+			st.Verdict = formatVerdict(rec.Verdict)
+		}
+		if state.Usage != nil {
+			st.Usage = state.Usage.Stages[s.Name]
+		}
+		r.Stages = append(r.Stages, st)
+	}
+
+	spans, _ := wf.loopSpans()
+	for _, sp := range spans {
+		l := reportLoop{Name: sp.Name, Until: sp.Until, Iterations: state.Loops[sp.Name]}
+		for _, e := range r.Timeline {
+			if contains(sp.Body, e.Name) {
+				l.Entries = append(l.Entries, e)
+			}
+		}
+		r.Loops = append(r.Loops, l)
+	}
+	return r, nil
+}
+
+func newReportEntry(rec StageRecord, start time.Time) reportEntry {
+	e := reportEntry{StageRecord: rec, Offset: rec.StartedAt.Sub(start), Runner: rec.Runner, Verdict: formatVerdict(rec.Verdict)}
+	if e.Offset < 0 {
+		e.Offset = 0
+	}
+	if n := len(rec.Calls); n > 0 && rec.Calls[n-1].Backend != rec.Runner {
+		e.Runner += " → " + rec.Calls[n-1].Backend
+	}
+	return e
+}
+
+// formatVerdict is a one-line "field: value" rendering of a verdict.
+func formatVerdict(v map[string]interface{}) string {
+	if len(v) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		val := v[k]
+		if list, ok := val.([]interface{}); ok {
+			val = fmt.Sprintf("%d item(s)", len(list))
+		}
+		parts = append(parts, fmt.Sprintf("%s: %v", k, val))
+	}
+	return truncate(strings.Join(parts, ", "), 120)
+}
+
+func (r *runReport) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s: %s\n\n", r.Name, r.Requirement)
+	fmt.Fprintf(&b, "| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Run | `%s` |\n", r.Run)
+	fmt.Fprintf(&b, "| Workflow | `%s` |\n", r.Workflow)
+	fmt.Fprintf(&b, "| Status | %s |\n", r.Status)
+	fmt.Fprintf(&b, "| Started | %s |\n", r.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "| Duration | %s |\n", r.Duration.Round(time.Second))
+	if r.Lineage != nil {
+		fmt.Fprintf(&b, "| Forked from | `%s` at %s |\n", r.Lineage.Parent, r.Lineage.Stage)
+	}
+	if len(r.Params) > 0 {
+		fmt.Fprintf(&b, "| Params | %s |\n", formatParams(r.Params))
+	}
+	b.WriteString("\n## Requirement\n\n" + r.Requirement + "\n")
+
+	if len(r.Timeline) > 0 {
+		b.WriteString("\n## Timeline\n\n| Start | Stage | Attempt | Runner | Status | Duration | Calls |\n|---|---|---|---|---|---|---|\n")
+		for _, e := range r.Timeline {
+			fmt.Fprintf(&b, "| +%s | %s | %d | %s | %s | %s | %d |\n", e.Offset.Round(time.Second), e.Name, e.Attempt, e.Runner, e.Status, e.Duration.Round(time.Millisecond), len(e.Calls))
+		}
+	}
+
+	b.WriteString("\n## Stages\n")
+	for _, s := range r.Stages {
+		fmt.Fprintf(&b, "\n### %s\n\n*%s, %s*", s.Name, s.Runner, s.Status)
+		if s.Usage != nil {
+			fmt.Fprintf(&b, " *(%s)*", formatUsage(*s.Usage))
+		}
+		b.WriteString("\n\n")
+		if s.Verdict != "" {
+			fmt.Fprintf(&b, "**Verdict:** %s\n\n", s.Verdict)
+		}
+		if s.Prompt != "" {
+			b.WriteString(mdDetails("Prompt", s.Prompt))
+		}
+		if s.Output != "" {
+			b.WriteString(mdDetails("Output", s.Output))
+		}
+	}
+
+	for _, l := range r.Loops {
+		if len(l.Entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## Loop: %s\n\n%d failed check(s), until `%s`\n\n| Stage | Attempt | Status | Verdict |\n|---|---|---|---|\n", l.Name, l.Iterations, l.Until)
+		for _, e := range l.Entries {
+			fmt.Fprintf(&b, "| %s | %d | %s | %s |\n", e.Name, e.Attempt, e.Status, mdCell(e.Verdict))
+		}
+	}
+
+	if r.Diff != "" {
+		b.WriteString("\n## File Changes\n\n" + demoteHeadings(r.Diff, 2) + "\n")
+	}
+	if r.Verify != "" {
+		b.WriteString("\n## Verify\n\n" + demoteHeadings(r.Verify, 2) + "\n")
+	}
+
+	if len(r.Gates) > 0 {
+		b.WriteString("\n## Approval Gates\n\n| Stage | Decision | Feedback |\n|---|---|---|\n")
+		for _, g := range r.Gates {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", g.Stage, g.Decision, mdCell(g.Feedback))
+		}
+	}
+	if len(r.Revisions) > 0 {
+		b.WriteString("\n## Revisions\n\n")
+		for _, rev := range r.Revisions {
+			fmt.Fprintf(&b, "- %d: restarted at %s (%s), set aside %s\n", rev.N, rev.From, rev.At.Format("2006-01-02 15:04:05"), strings.Join(rev.Stages, ", "))
+		}
+	}
+
+	if r.Usage != nil {
+		b.WriteString("\n## Costs\n\n")
+		fmt.Fprintf(&b, "Total: %s\n", formatUsage(r.Usage.Total))
+		if r.Budget != "" {
+			fmt.Fprintf(&b, "\nBudget: %s\n", r.Budget)
+		}
+		if len(r.Costs) > 0 {
+			b.WriteString("\n| Stage | Calls | Tokens | Cost |\n|---|---|---|---|\n")
+			for _, c := range r.Costs {
+				fmt.Fprintf(&b, "| %s | %d | ~%d | $%.2f |\n", c.Name, c.Calls, c.Tokens, c.Cost)
+			}
+		}
+	}
+	return b.String()
+}
+
+// costs lists per-stage usage in workflow order, then any other stages
+// (sub-workflow stages) by name.
+func costs(wf *Workflow, usage *RunUsage) []reportCost {
+	var out []reportCost
+	seen := make(map[string]bool)
+	for _, s := range wf.Stages {
+		if u, ok := usage.Stages[s.Name]; ok {
+			out = append(out, reportCost{Name: s.Name, Usage: *u})
+			seen[s.Name] = true
+		}
+	}
+	var rest []string
+	for name := range usage.Stages {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		out = append(out, reportCost{Name: name, Usage: *usage.Stages[name]})
+	}
+	return out
+}
+
+// mdDetails is a collapsed block holding text that may itself be Markdown.
+func mdDetails(summary, body string) string {
+	fence := "```"
+	for strings.Contains(body, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("<details><summary>%s</summary>\n\n%s\n%s\n%s\n\n</details>\n\n", summary, fence, strings.TrimRight(body, "\n"), fence)
+}
+
+func mdCell(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "|", "\\|"), "\n", " ")
+}
+
+// demoteHeadings pushes Markdown headings down n levels, so an embedded
+// document sits under the report's own headings.
+func demoteHeadings(md string, n int) string {
+	lines := strings.Split(md, "\n")
+	inFence := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			inFence = !inFence
+		}
+		if !inFence && strings.HasPrefix(line, "#") {
+			lines[i] = strings.Repeat("#", n) + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// changeLine is one line of diff.md with its CSS class in the HTML report.
+type changeLine struct {
+	Class string
+	Text  string
+}
+
+// changeLines classes the lines of diff.md. It lists files rather than
+// diffing them, so only the bullets of the New and Deleted sections are
+// colored; file contents are shown as they are.
+func changeLines(diff string) []changeLine {
+	var out []changeLine
+	section, inFence := "", false
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "```"):
+			inFence = !inFence
+		case inFence:
+		case strings.HasPrefix(line, "#"):
+			section, class = line, "head"
+		case strings.HasPrefix(line, "- "):
+			switch section {
+			case "## New Files":
+				class = "add"
+			case "## Deleted Files":
+				class = "del"
+			}
+		}
+		out = append(out, changeLine{Class: class, Text: line})
+	}
+	return out
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"dur":     func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+	"secs":    func(d time.Duration) string { return d.Round(time.Second).String() },
+	"time":    func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	"usage":   func(u Usage) string { return formatUsage(u) },
+	"changes": changeLines,
+	"params":  formatParams,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}: {{.Requirement}}</title>
+<style>
+body { font: 14px/1.5 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 1000px; margin: 2em auto; padding: 0 1em; color: #1f2328; }
+h1 { font-size: 1.6em; border-bottom: 1px solid #d0d7de; padding-bottom: .3em; }
+h2 { font-size: 1.3em; margin-top: 2em; border-bottom: 1px solid #d0d7de; padding-bottom: .2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #d0d7de; padding: 4px 10px; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; }
+pre { background: #f6f8fa; padding: 10px; overflow-x: auto; white-space: pre-wrap; word-break: break-word; }
+details { margin: .5em 0; }
+summary { cursor: pointer; font-weight: 600; }
+.done, .completed { color: #1a7f37; }
+.failed { color: #cf222e; }
+.stopped, .running { color: #9a6700; }
+.skipped, .pending, .meta { color: #656d76; }
+.add { background: #dafbe1; display: block; }
+.del { background: #ffebe9; display: block; }
+.head { font-weight: 600; display: block; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<table>
+<tr><th>Run</th><td><code>{{.Run}}</code></td></tr>
+<tr><th>Workflow</th><td><code>{{.Workflow}}</code></td></tr>
+<tr><th>Status</th><td class="{{.Status}}">{{.Status}}</td></tr>
+<tr><th>Started</th><td>{{time .StartedAt}}</td></tr>
+<tr><th>Duration</th><td>{{secs .Duration}}</td></tr>
+{{- with .Lineage}}
+<tr><th>Forked from</th><td><code>{{.Parent}}</code> at {{.Stage}}</td></tr>
+{{- end}}
+{{- if .Params}}
+<tr><th>Params</th><td>{{params .Params}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Requirement</h2>
+<pre>{{.Requirement}}</pre>
+
+{{- if .Timeline}}
+<h2>Timeline</h2>
+<table>
+<tr><th>Start</th><th>Stage</th><th>Attempt</th><th>Runner</th><th>Status</th><th>Duration</th><th>Calls</th></tr>
+{{- range .Timeline}}
+<tr><td>+{{secs .Offset}}</td><td>{{.Name}}</td><td>{{.Attempt}}</td><td>{{.Runner}}</td><td class="{{.Status}}">{{.Status}}{{with .Error}}<div class="meta">{{.}}</div>{{end}}</td><td>{{dur .Duration}}</td><td>{{len .Calls}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>Stages</h2>
+{{- range .Stages}}
+<h3>{{.Name}} <span class="{{.Status}}">{{.Status}}</span></h3>
+<p class="meta">{{.Runner}}{{with .Usage}} · {{usage .}}{{end}}</p>
+{{- with .Verdict}}
+<p><strong>Verdict:</strong> {{.}}</p>
+{{- end}}
+{{- with .Prompt}}
+<details><summary>Prompt</summary><pre>{{.}}</pre></details>
+{{- end}}
+{{- with .Output}}
+<details><summary>Output</summary><pre>{{.}}</pre></details>
+{{- end}}
+{{- end}}
+
+{{- range .Loops}}
+{{- if .Entries}}
+<h2>Loop: {{.Name}}</h2>
+<p>{{.Iterations}} failed check(s), until <code>{{.Until}}</code></p>
+<table>
+<tr><th>Stage</th><th>Attempt</th><th>Status</th><th>Verdict</th></tr>
+{{- range .Entries}}
+<tr><td>{{.Name}}</td><td>{{.Attempt}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{.Verdict}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+
+{{- with .Diff}}
+<h2>File Changes</h2>
+<pre>{{range changes .}}<span class="{{.Class}}">{{.Text}}</span>
+{{end}}</pre>
+{{- end}}
+
+{{- with .Verify}}
+<h2>Verify</h2>
+<pre>{{.}}</pre>
+{{- end}}
+
+{{- if .Gates}}
+<h2>Approval Gates</h2>
+<table>
+<tr><th>Stage</th><th>Decision</th><th>Feedback</th><th>At</th></tr>
+{{- range .Gates}}
+<tr><td>{{.Stage}}</td><td>{{.Decision}}</td><td>{{.Feedback}}</td><td>{{time .At}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+{{- if .Revisions}}
+<h2>Revisions</h2>
+<ul>
+{{- range .Revisions}}
+<li>{{.N}}: restarted at {{.From}} ({{time .At}}), set aside {{range $i, $s := .Stages}}{{if $i}}, {{end}}{{$s}}{{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+
+{{- with .Usage}}
+<h2>Costs</h2>
+<p>Total: {{usage .Total}}{{with $.Budget}} · Budget: {{.}}{{end}}</p>
+{{- if $.Costs}}
+<table>
+<tr><th>Stage</th><th>Calls</th><th>Tokens</th><th>Cost</th></tr>
+{{- range $.Costs}}
+<tr><td>{{.Name}}</td><td>{{.Calls}}</td><td>~{{.Tokens}}</td><td>${{printf "%.2f" .Cost}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resumeOptions restart a run somewhere other than where it stopped.
+type resumeOptions struct {
+	From       string // Stage to restart at; it and everything after it run again
+	Backend    string // Backend for that stage
+	Model      string // Model for that stage
+	EditPrompt bool   // Edit the stage's prompt in $EDITOR first
+	PromptFile string // Replace the stage's prompt with this file
+}
+
+// Revision records the outputs set aside when a run was restarted at a
+// stage. They are kept in revisions/<n>/ inside the run directory.
+type Revision struct {
+	N            int       `json:"n"`
+	From         string    `json:"from"`
+	Stages       []string  `json:"stages"` // Stages whose results were set aside
+	Backend      string    `json:"backend,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	PromptEdited bool      `json:"promptEdited,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// parseResumeArgs reads "[run] [--from stage] [--backend b] [--model m]
+// [--edit-prompt] [--prompt-file f]".
+func parseResumeArgs(args []string) (string, resumeOptions, error) {
+	var folder string
+	var opts resumeOptions
+	for i := 0; i < len(args); i++ {
+		value := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s needs a value", args[i])
+			}
+			i++
+			return args[i], nil
+		}
+		var err error
+		switch args[i] {
+		case "--from":
+			opts.From, err = value()
+		case "--backend", "-b":
+			opts.Backend, err = value()
+		case "--model", "-m":
+			opts.Model, err = value()
+		case "--prompt-file":
+			opts.PromptFile, err = value()
+		case "--edit-prompt":
+			opts.EditPrompt = true
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return "", opts, fmt.Errorf("unknown option %s", args[i])
+			}
+			if folder != "" {
+				return "", opts, fmt.Errorf("unexpected argument %s", args[i])
+			}
+			folder = args[i]
+		}
+		if err != nil {
+			return "", opts, err
+		}
+	}
+	if opts.From == "" && (opts.Backend != "" || opts.Model != "" || opts.EditPrompt || opts.PromptFile != "") {
+		return "", opts, fmt.Errorf("--backend, --model and prompt changes need --from <stage>")
+	}
+	return folder, opts, nil
+}
+
+// restartAt prepares a run to continue at stage opts.From. That stage and
+// everything downstream of it lose their results, which are moved into a
+// new revision directory, and the overrides are applied to the stage. It
+// returns the stage index to continue from.
+func (wf *Workflow) restartAt(ctx *WorkflowContext, opts resumeOptions) (int, error) {
+	idx, err := wf.stageIndex(opts.From)
+	if err != nil {
+		return 0, err
+	}
+	n := len(ctx.Revisions) + 1
+	revDir := filepath.Join(ctx.WorkDir, "revisions", strconv.Itoa(n))
+	if err := os.MkdirAll(revDir, 0755); err != nil {
+		return 0, err
+	}
+	edited, err := wf.override(ctx, idx, opts, revDir)
+	if err != nil {
+		os.RemoveAll(revDir)
+		return 0, err
+	}
+
+	rev := Revision{N: n, From: opts.From, Backend: opts.Backend, Model: opts.Model, PromptEdited: edited, At: time.Now()}
+	rev.Stages = wf.clearFrom(ctx, idx, revDir)
+	ctx.Revisions = append(ctx.Revisions, rev)
+	ctx.log("## Restarted at %s (revision %d)\n\nPrevious results of %s are in %s\n\n", opts.From, n, strings.Join(rev.Stages, ", "), revDir)
+	fmt.Printf("%s Set aside %d stage result(s) as revision %d: %s\n", dim("│"), len(rev.Stages), n, revDir)
+
+	if wf.isDAG() {
+		return 0, nil
+	}
+	return idx, nil
+}
+
+func (wf *Workflow) stageIndex(name string) (int, error) {
+	for i, s := range wf.Stages {
+		if s.Name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("workflow %s has no stage %s", wf.Key, name)
+}
+
+// override applies the backend, model and prompt changes of opts to stage
+// idx, in the run's own copy of the definition (which is saved with the
+// checkpoint). The prompt it replaces is kept in saveDir if set. It
+// reports whether the prompt changed.
+func (wf *Workflow) override(ctx *WorkflowContext, idx int, opts resumeOptions, saveDir string) (bool, error) {
+	wf.Stages = copyStages(wf.Stages)
+	stage := &wf.Stages[idx]
+	if opts.Backend != "" {
+		if _, ok := config.Backends[opts.Backend]; !ok {
+			return false, fmt.Errorf("unknown backend: %s", opts.Backend)
+		}
+		if stage.Type == "shell" || stage.Workflow != "" {
+			return false, fmt.Errorf("stage %s doesn't call a backend", stage.Name)
+		}
+		stage.Backend = opts.Backend
+		stage.Model = ""
+	}
+	if opts.Model != "" {
+		stage.Model = opts.Model
+	}
+	if !opts.EditPrompt && opts.PromptFile == "" {
+		return false, nil
+	}
+	if stage.Prompt == "" {
+		return false, fmt.Errorf("stage %s has no prompt to edit", stage.Name)
+	}
+	if saveDir != "" {
+		os.WriteFile(filepath.Join(saveDir, stage.Name+".prompt.md"), []byte(stage.Prompt), 0644)
+	}
+	prompt, err := newPrompt(ctx, idx, stage, opts)
+	if err != nil {
+		return false, err
+	}
+	edited := prompt != stage.Prompt
+	stage.Prompt = prompt
+	return edited, nil
+}
+
+// clearFrom drops the results of stage idx and everything downstream of
+// it, so they run again. Their files are moved into keepDir, or deleted if
+// it is empty, and their previous results are written to
+// keepDir/results.json. It returns the names of the cleared stages.
+func (wf *Workflow) clearFrom(ctx *WorkflowContext, idx int, keepDir string) []string {
+	downstream := wf.downstream(idx)
+	var cleared []string
+	old := make(map[string]string)
+	for _, k := range downstream {
+		s := wf.Stages[k]
+		cleared = append(cleared, s.Name)
+		for name, result := range ctx.Results {
+			if name == s.Name || strings.HasPrefix(name, s.Name+subResultSep) {
+				old[name] = result
+				delete(ctx.Results, name)
+			}
+		}
+		files := []string{
+			ctx.Outputs[s.Name],
+			filepath.Join(ctx.WorkDir, fmt.Sprintf("%d.%s.log", k, s.Name)),
+			filepath.Join(ctx.WorkDir, fmt.Sprintf("%d.%s.edit.md", k, s.Name)),
+		}
+		if s.Workflow != "" {
+			files = append(files, filepath.Join(ctx.WorkDir, s.Name))
+		}
+		if s.Backend == "auto" && s.Name == "verify" {
+			files = append(files, filepath.Join(ctx.WorkDir, "diff.md"))
+			if diff, ok := ctx.Results["diff"]; ok {
+				old["diff"] = diff
+				delete(ctx.Results, "diff")
+			}
+		}
+		for _, f := range files {
+			switch {
+			case f == "":
+			case keepDir != "":
+				os.Rename(f, filepath.Join(keepDir, filepath.Base(f)))
+			default:
+				os.RemoveAll(f)
+			}
+		}
+		delete(ctx.Outputs, s.Name)
+		ctx.unskip(s.Name)
+		if ctx.Nodes != nil {
+			ctx.Nodes[s.Name] = nodePending
+		}
+	}
+	if keepDir != "" {
+		data, _ := json.MarshalIndent(old, "", "  ")
+		os.WriteFile(filepath.Join(keepDir, "results.json"), data, 0644)
+	}
+
+	// Loops that will run again start counting from scratch
+	spans, _ := wf.loopSpans()
+	for _, sp := range spans {
+		for _, k := range downstream {
+			if k >= sp.Start && k <= sp.End {
+				ctx.Loops[sp.Name] = 0
+			}
+		}
+	}
+	return cleared
+}
+
+// newPrompt returns the replacement prompt for a restarted stage, from
+// --prompt-file or the editor.
+func newPrompt(ctx *WorkflowContext, idx int, stage *Stage, opts resumeOptions) (string, error) {
+	if opts.PromptFile != "" {
+		data, err := os.ReadFile(opts.PromptFile)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	path := filepath.Join(ctx.WorkDir, fmt.Sprintf("%d.%s.prompt.md", idx, stage.Name))
+	if err := os.WriteFile(path, []byte(stage.Prompt), 0644); err != nil {
+		return "", err
+	}
+	if err := openEditor(path); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// downstream lists the stage at idx and every stage that runs after it
+// and may depend on it: all later stages, or in a DAG everything that
+// depends on it directly or indirectly.
+func (wf *Workflow) downstream(idx int) []int {
+	if !wf.isDAG() {
+		var out []int
+		for k := idx; k < len(wf.Stages); k++ {
+			out = append(out, k)
+		}
+		return out
+	}
+	affected := map[string]bool{wf.Stages[idx].Name: true}
+	for changed := true; changed; {
+		changed = false
+		for _, s := range wf.Stages {
+			if affected[s.Name] {
+				continue
+			}
+			for _, dep := range s.DependsOn {
+				if affected[dep] {
+					affected[s.Name] = true
+					changed = true
+				}
+			}
+		}
+	}
+	var out []int
+	for k, s := range wf.Stages {
+		if affected[s.Name] {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func (ctx *WorkflowContext) unskip(stage string) {
+	kept := ctx.Skipped[:0]
+	for _, s := range ctx.Skipped {
+		if s != stage {
+			kept = append(kept, s)
+		}
+	}
+	ctx.Skipped = kept
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// ShellCommand is a command run by a shell stage or a pre/post hook.
+type ShellCommand struct {
+	Command      string            `json:"command,omitempty"`
+	Dir          string            `json:"dir,omitempty"`          // Relative to the project root
+	Env          map[string]string `json:"env,omitempty"`          // Added to the inherited environment
+	Timeout      string            `json:"timeout,omitempty"`      // e.g. "30s", "5m"; no limit if empty
+	AllowFailure bool              `json:"allowFailure,omitempty"` // A non-zero exit doesn't fail the stage
+}
+
+// Hook is a pre/post command. In JSON it can be given as just the command
+// string. (ShellCommand itself is embedded in Stage, so it can't have its
+// own UnmarshalJSON.)
+type Hook ShellCommand
+
+func (h *Hook) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*h = Hook{Command: command}
+		return nil
+	}
+	return json.Unmarshal(data, (*ShellCommand)(h))
+}
+
+func (c *ShellCommand) check() error {
+	if c.Command == "" {
+		return fmt.Errorf("missing command")
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("timeout: %w", err)
+		}
+	}
+	if c.Dir != "" {
+		if err := checkProjectPath(c.Dir); err != nil {
+			return fmt.Errorf("dir: %w", err)
+		}
+	}
+	return nil
+}
+
+// run executes the command with sh, streaming combined output to out and
+// returning it. The run directory and stage name are passed in the
+// environment rather than templated into the command, so stage output
+// never ends up in a shell line.
+func (c *ShellCommand) run(ctx *WorkflowContext, stage string, out io.Writer) (string, error) {
+	runCtx := context.Background()
+	if c.Timeout != "" {
+		d, _ := time.ParseDuration(c.Timeout)
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, d)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(runCtx, "sh", "-c", c.Command)
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(),
+		"AI_PROXY_STAGE="+stage,
+		"AI_PROXY_REQUIREMENT="+ctx.Requirement,
+	)
+	if ctx.WorkDir != "" {
+		if abs, err := filepath.Abs(ctx.WorkDir); err == nil {
+			cmd.Env = append(cmd.Env, "AI_PROXY_RUN_DIR="+abs)
+		}
+	}
+	for k, v := range c.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	var buf bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&buf, out)
+	cmd.Stderr = cmd.Stdout
+	// Children of sh may hold the output pipe open after a timeout kill
+	cmd.WaitDelay = time.Second
+
+	fmt.Fprintf(out, "%s $ %s\n", dim("│"), c.Command)
+	err := cmd.Run()
+	if runCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", c.Timeout)
+	}
+	if err != nil && c.AllowFailure {
+		fmt.Fprintf(out, "%s %s: %v (allowed)\n", yellow("!"), c.Command, err)
+		fmt.Fprintf(&buf, "\n(%v)\n", err)
+		err = nil
+	}
+	if err != nil {
+		return buf.String(), fmt.Errorf("%s: %w", c.Command, err)
+	}
+	return buf.String(), nil
+}
+
+// runHooks runs a stage's pre or post hooks in order, stopping at the
+// first failure.
+func runHooks(ctx *WorkflowContext, stage *Stage, which string, hooks []Hook) error {
+	for i := range hooks {
+		hook := (*ShellCommand)(&hooks[i])
+		ctx.log("### %s hook\n```\n%s\n```\n\n", which, hook.Command)
+		out, err := hook.run(ctx, stage.Name, ctx.output())
+		if out != "" {
+			ctx.log("```\n%s\n```\n\n", truncate(out, 2000))
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook: %w", which, err)
+		}
+	}
+	return nil
+}
+
+// runner names what executes a stage, for progress lines.
+func (s *Stage) runner() string {
+	if s.Type == "shell" {
+		return "shell"
+	}
+	if s.Workflow != "" {
+		return "workflow " + s.Workflow
+	}
+	return s.Backend
+}
+
+// check validates the stage's own settings.
+func (s *Stage) check() error {
+	switch s.Type {
+	case "":
+	case "workflow":
+		if s.Workflow == "" {
+			return fmt.Errorf("missing workflow")
+		}
+	case "shell":
+		if err := s.ShellCommand.check(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown type %q", s.Type)
+	}
+	if s.Type != "shell" && s.Command != "" {
+		return fmt.Errorf("command is only used by shell stages")
+	}
+	if s.Workflow != "" {
+		if s.Type == "shell" || s.Skill != "" {
+			return fmt.Errorf("workflow stages can't also be shell or skill stages")
+		}
+		if getWorkflow(s.Workflow) == nil {
+			return fmt.Errorf("unknown workflow %s", s.Workflow)
+		}
+	}
+	for _, hooks := range [][]Hook{s.Pre, s.Post} {
+		for i := range hooks {
+			if err := (*ShellCommand)(&hooks[i]).check(); err != nil {
+				return fmt.Errorf("hook: %w", err)
+			}
+		}
+	}
+	if s.Schema != nil {
+		if err := s.Schema.check(); err != nil {
+			return err
+		}
+	}
+	if s.Budget != nil {
+		if err := s.Budget.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -65,9 +65,13 @@ func loadSkillsFromDir(dir string) {
 		skillPath := filepath.Join(dir, e.Name())
 		skill, err := loadSkill(skillPath)
 		if err != nil {
+			if _, statErr := os.Stat(filepath.Join(skillPath, "skill.yaml")); statErr == nil {
+				fmt.Printf("%s skill %s: %v\n", yellow("!"), skillPath, err)
+			}
 			continue
 		}
 		skills[skill.Name] = skill
+		warnShadow("skill", skill.Name, skillOrigins.set(skill.Name, skillPath, ""))
 	}
 }
 
@@ -97,12 +101,19 @@ func loadSkill(path string) (*Skill, error) {
 }
 
 func (s *Skill) Run(inputs map[string]string, ctx *WorkflowContext) (string, error) {
-	// Build prompt from template
-	prompt := s.Prompt
-
-	// Replace inputs
+	// Every declared input is present (possibly empty) so {{if .name}}
+	// works for optional ones; anything else is a template error.
+	data := map[string]interface{}{
+		"Requirement":    "",
+		"ProjectContext": "",
+		"DiffContent":    "",
+	}
+	if ctx != nil {
+		data = promptData(ctx)
+	} else if strings.Contains(s.Prompt, "ProjectContext") {
+		data["ProjectContext"] = scanProjectContext()
+	}
 	for _, input := range s.Inputs {
-		placeholder := fmt.Sprintf("{{.%s}}", input.Name)
 		value := inputs[input.Name]
 		if value == "" {
 			value = input.Default
@@ -110,38 +121,34 @@ func (s *Skill) Run(inputs map[string]string, ctx *WorkflowContext) (string, err
 		if value == "" && input.Required {
 			return "", fmt.Errorf("missing required input: %s", input.Name)
 		}
-		prompt = strings.ReplaceAll(prompt, placeholder, value)
+		data[input.Name] = value
 	}
-
-	// Replace standard context variables if ctx provided
-	if ctx != nil {
-		prompt = strings.ReplaceAll(prompt, "{{.ProjectContext}}", ctx.Results["project-context"])
-		prompt = strings.ReplaceAll(prompt, "{{.Requirement}}", ctx.Requirement)
-		prompt = strings.ReplaceAll(prompt, "{{.DiffContent}}", ctx.Results["diff"])
+	prompt, err := renderTemplate(filepath.Join(s.Path, "prompt.md"), s.Prompt, data)
+	if err != nil {
+		return "", err
 	}
+	prompt = ctx.withFeedback(prompt)
 
 	// Execute
-	oldBackend := current
-	oldModel := currentModel
-
+	backend := current
+	model := currentModel
 	if s.Stage.Backend != "" {
-		current = s.Stage.Backend
+		backend = s.Stage.Backend
 	}
 	if s.Stage.Model != "" {
-		currentModel = s.Stage.Model
+		model = s.Stage.Model
 	}
 
-	var result string
 	if s.Stage.Interactive {
-		result = callInteractive(prompt)
-	} else {
-		result = call(prompt)
+		tty, err := interactiveStage(s.Name)
+		if err != nil {
+			return "", err
+		}
+		if tty {
+			return ctx.callStage(backend, model, prompt, true)
+		}
 	}
-
-	current = oldBackend
-	currentModel = oldModel
-
-	return result, nil
+	return ctx.callStage(backend, model, prompt, false)
 }
 
 func (s *Skill) ToStage() Stage {
@@ -333,6 +340,7 @@ func installSkill(url string) {
 	skill.Path = skillDir
 	skill.Prompt = string(promptData)
 	skills[skill.Name] = &skill
+	skillOrigins.set(skill.Name, skillDir, "installed from "+url)
 
 	fmt.Printf("%s Installed skill: %s v%s\n", green("✓"), skill.Name, skill.Version)
 }
@@ -371,6 +379,7 @@ func removeSkill(name string) {
 	}
 
 	delete(skills, name)
+	delete(skillOrigins, name)
 	fmt.Printf("%s Removed skill: %s\n", green("✓"), name)
 }
 
@@ -387,6 +396,7 @@ func showSkillInfo(name string) {
 	fmt.Printf("%s %s\n", dim("Author:"), skill.Author)
 	fmt.Printf("%s %s\n", dim("Backend:"), skill.Stage.Backend)
 	fmt.Printf("%s %s\n", dim("Path:"), skill.Path)
+	printOrigin(skillOrigins[name])
 
 	if len(skill.Inputs) > 0 {
 		fmt.Printf("%s\n", dim("Inputs:"))
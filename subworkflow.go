@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A stage with "workflow" set runs another workflow as one step. Its inputs
+// are rendered against the parent context: "requirement" becomes the
+// sub-workflow's requirement (default: the parent's) and every other key
+// one of its parameters. The sub-run lives in <run dir>/<stage name>/ with
+// its own state.json, so resuming the parent resumes the sub-run too.
+// Earlier executions of the stage in the same run are kept as
+// <stage name>.<n>/.
+
+// subResultSep joins a sub-workflow stage's name and one of its own stages
+// in the parent's results, e.g. "tests/verify".
+const subResultSep = "/"
+
+// runSubWorkflow runs (or resumes) the sub-workflow of a stage and returns
+// a summary of it as the stage result, plus each of its stage results
+// under "<stage>/<sub stage>".
+func runSubWorkflow(stage *Stage, ctx *WorkflowContext) (string, map[string]string, error) {
+	for _, key := range ctx.Stack {
+		if key == stage.Workflow {
+			return "", nil, fmt.Errorf("workflow %s runs itself (%s → %s)", stage.Workflow, strings.Join(ctx.Stack, " → "), stage.Workflow)
+		}
+	}
+
+	inputs := make(map[string]string, len(stage.Inputs))
+	for k, v := range stage.Inputs {
+		value, err := renderTemplate(stageSource(ctx, stage)+" input "+k, v, promptData(ctx))
+		if err != nil {
+			return "", nil, err
+		}
+		inputs[k] = value
+	}
+	requirement := ctx.Requirement
+	if r, ok := inputs["requirement"]; ok {
+		requirement = r
+		delete(inputs, "requirement")
+	}
+
+	workDir := filepath.Join(ctx.WorkDir, stage.Name)
+	if _, again := ctx.Results[stage.Name]; again {
+		if err := retireSubRun(workDir); err != nil {
+			return "", nil, err
+		}
+	}
+	sub, subctx, next, done, err := openSubRun(stage, ctx, workDir, requirement, inputs)
+	if err != nil {
+		return "", nil, err
+	}
+	if f, ok := subctx.LogFile.(*os.File); ok && f != nil {
+		defer f.Close()
+	}
+
+	if !done {
+		fmt.Fprintf(ctx.output(), "\n%s Sub-workflow: %s %s\n", cyan("▶"), sub.Name, dim("("+workDir+")"))
+		if err := sub.execute(subctx, next); err != nil {
+			return "", nil, fmt.Errorf("sub-workflow %s: %w", sub.Key, err)
+		}
+		state, err := loadCheckpoint(workDir)
+		if err != nil {
+			return "", nil, err
+		}
+		if state.Status == statusStopped {
+			return "", nil, fmt.Errorf("sub-workflow %s: %w", sub.Key, errStopped)
+		}
+		if state.Status != statusCompleted {
+			return "", nil, fmt.Errorf("sub-workflow %s %s before the end", sub.Key, state.Status)
+		}
+	}
+
+	extra := make(map[string]string)
+	for name, result := range subctx.Results {
+		if name != "project-context" {
+			extra[stage.Name+subResultSep+name] = result
+		}
+	}
+	return summarizeSubRun(sub, subctx), extra, nil
+}
+
+// checkSubWorkflows rejects a workflow that ends up running itself through
+// its workflow stages. path holds the keys from the outermost workflow down.
+func (wf *Workflow) checkSubWorkflows(path []string) error {
+	for _, s := range wf.Stages {
+		if s.Workflow == "" {
+			continue
+		}
+		for _, key := range path {
+			if key == s.Workflow {
+				return fmt.Errorf("stage %s: workflow %s runs itself (%s → %s)", s.Name, s.Workflow, strings.Join(path, " → "), s.Workflow)
+			}
+		}
+		if sub := getWorkflow(s.Workflow); sub != nil {
+			if err := sub.checkSubWorkflows(append(path, s.Workflow)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retireSubRun moves a completed sub-run out of the way when its stage runs
+// again in the same run (a loop iteration or a rejected gate), keeping it
+// as <dir>.<n>. An unfinished sub-run stays, so it can be resumed.
+func retireSubRun(workDir string) error {
+	state, err := loadCheckpoint(workDir)
+	if err != nil || state.Status != statusCompleted {
+		return nil
+	}
+	for n := 1; ; n++ {
+		old := fmt.Sprintf("%s.%d", workDir, n)
+		if _, err := os.Stat(old); os.IsNotExist(err) {
+			return os.Rename(workDir, old)
+		}
+	}
+}
+
+// openSubRun starts a new sub-run in workDir, or picks up the one a
+// previous attempt left there. It returns the index to continue from, and
+// done if that sub-run already completed.
+func openSubRun(stage *Stage, ctx *WorkflowContext, workDir, requirement string, inputs map[string]string) (*Workflow, *WorkflowContext, int, bool, error) {
+	stack := append(append([]string(nil), ctx.Stack...), ctx.Workflow.Key)
+
+	if state, err := loadCheckpoint(workDir); err == nil {
+		sub := state.Definition
+		if sub == nil {
+			sub = getWorkflow(state.WorkflowName)
+		}
+		if sub == nil {
+			return nil, nil, 0, false, fmt.Errorf("unknown workflow: %s", state.WorkflowName)
+		}
+		logFile, _ := os.OpenFile(filepath.Join(workDir, "log.md"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		subctx := restoreContext(state, logFile)
+		subctx.WorkDir = workDir // The parent run may have been forked
+		subctx.Workflow = sub
+		subctx.Stack = stack
+		subctx.Meter = newMeter(sub, state.Usage)
+		subctx.Meter.under(ctx.Meter, stage.Name)
+		subctx.Manifest = openManifest(workDir)
+		subctx.Output = ctx.Output
+		if state.Status == statusCompleted {
+			return sub, subctx, 0, true, nil
+		}
+		next := state.CurrentStage + 1
+		if state.NextStage != nil {
+			next = *state.NextStage
+		}
+		subctx.log("## Resumed at %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
+		return sub, subctx, next, false, nil
+	}
+
+	sub := getWorkflow(stage.Workflow)
+	if sub == nil {
+		return nil, nil, 0, false, fmt.Errorf("unknown workflow: %s", stage.Workflow)
+	}
+	if err := sub.validate(); err != nil {
+		return nil, nil, 0, false, fmt.Errorf("workflow %s: %w", sub.Key, err)
+	}
+	params, err := sub.resolveParams(inputs, !runOpts.NonInteractive)
+	if err != nil {
+		return nil, nil, 0, false, fmt.Errorf("workflow %s: %w", sub.Key, err)
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, nil, 0, false, err
+	}
+	logFile, _ := os.Create(filepath.Join(workDir, "log.md"))
+	subctx := &WorkflowContext{
+		Requirement:    requirement,
+		WorkDir:        workDir,
+		Results:        map[string]string{"project-context": ctx.Results["project-context"]},
+		LogFile:        logFile,
+		Output:         ctx.Output,
+		BeforeSnapshot: ctx.BeforeSnapshot,
+		Timer:          NewStageTimer(),
+		Loops:          make(map[string]int),
+		StartedAt:      time.Now(),
+		Backend:        ctx.Backend,
+		Workflow:       sub,
+		Params:         params,
+		Stack:          stack,
+		Meter:          newMeter(sub, nil),
+		Manifest:       openManifest(workDir),
+	}
+	subctx.Meter.under(ctx.Meter, stage.Name)
+	subctx.log("# Workflow: %s (from %s, stage %s)\n", sub.Name, ctx.Workflow.Key, stage.Name)
+	subctx.log("**Requirement:** %s\n\n", requirement)
+	return sub, subctx, 0, false, nil
+}
+
+// summarizeSubRun is the parent's view of a finished sub-run: each stage's
+// output under its name.
+func summarizeSubRun(sub *Workflow, subctx *WorkflowContext) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", sub.Name)
+	for _, s := range sub.Stages {
+		result, ok := subctx.Results[s.Name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", s.Name, strings.TrimSpace(stripANSI(result)))
+	}
+	if len(subctx.Skipped) > 0 {
+		skipped := append([]string(nil), subctx.Skipped...)
+		sort.Strings(skipped)
+		fmt.Fprintf(&b, "Skipped: %s\n", strings.Join(skipped, ", "))
+	}
+	return b.String()
+}
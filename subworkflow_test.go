@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A workflow stage inside a loop runs its sub-workflow on every iteration,
+// keeping the earlier sub-runs next to the current one.
+func TestSubWorkflowInLoop(t *testing.T) {
+	t.Chdir(t.TempDir())
+	config = defaultConfig()
+	oldOpts := runOpts
+	runOpts = runOptions{NonInteractive: true}
+	defer func() { runOpts = oldOpts }()
+
+	defaultWorkflows["subtest-inner"] = Workflow{Name: "Inner", Stages: []Stage{
+		{Name: "count", Type: "shell", ShellCommand: ShellCommand{Command: "echo run >> count.txt"}},
+	}}
+	defer delete(defaultWorkflows, "subtest-inner")
+	outer := &Workflow{Key: "subtest-outer", Name: "Outer",
+		Stages: []Stage{{Name: "inner", Workflow: "subtest-inner"}},
+		Loops:  []Loop{{Name: "again", Body: []string{"inner"}, Until: "false", MaxIterations: 3, OnExhausted: "continue"}},
+	}
+
+	dir, err := outer.Run("count runs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("count.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "run"); n != 3 {
+		t.Errorf("inner workflow ran %d times, want 3", n)
+	}
+	for _, sub := range []string{"inner", "inner.1", "inner.2"} {
+		state, err := loadCheckpoint(filepath.Join(dir, sub))
+		if err != nil || state.Status != statusCompleted {
+			t.Errorf("%s: state %v, %v; want a completed sub-run", sub, state, err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OutputSchema declares the structured verdict a stage must include in its
+// output, either as YAML front-matter or as a fenced JSON block.
+type OutputSchema struct {
+	Fields map[string]SchemaField `json:"fields"`
+}
+
+type SchemaField struct {
+	Type     string   `json:"type,omitempty"` // string (default), number, boolean, array, object
+	Enum     []string `json:"enum,omitempty"` // Allowed values for strings, matched case-insensitively
+	Required bool     `json:"required,omitempty"`
+}
+
+func (s *OutputSchema) names() []string {
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describe lists the fields for the re-ask prompt.
+func (s *OutputSchema) describe() string {
+	var b strings.Builder
+	for _, name := range s.names() {
+		f := s.Fields[name]
+		typ := f.Type
+		if typ == "" {
+			typ = "string"
+		}
+		fmt.Fprintf(&b, "- %s (%s", name, typ)
+		if len(f.Enum) > 0 {
+			fmt.Fprintf(&b, ": one of %s", strings.Join(f.Enum, ", "))
+		}
+		if f.Required {
+			b.WriteString(", required")
+		}
+		b.WriteString(")\n")
+	}
+	return b.String()
+}
+
+// check validates the schema itself, at load time.
+func (s *OutputSchema) check() error {
+	if len(s.Fields) == 0 {
+		return fmt.Errorf("schema has no fields")
+	}
+	for _, name := range s.names() {
+		switch s.Fields[name].Type {
+		case "", "string", "number", "boolean", "array", "object":
+		default:
+			return fmt.Errorf("schema field %s: unknown type %q", name, s.Fields[name].Type)
+		}
+	}
+	return nil
+}
+
+const verdictMarker = "\n\n<!-- verdict -->\n"
+
+var jsonBlockRe = regexp.MustCompile("(?s)```(?:json)?[ \t]*\n(\\{.*?\\})\\s*```")
+
+// extractVerdict finds the structured block in a stage's output: leading
+// front-matter, else the last fenced JSON object, else the whole output as
+// JSON.
+func extractVerdict(output string) (map[string]interface{}, error) {
+	text := strings.TrimSpace(stripANSI(output))
+	var v map[string]interface{}
+
+	if strings.HasPrefix(text, "---\n") {
+		if end := strings.Index(text[4:], "\n---"); end >= 0 {
+			if err := yaml.Unmarshal([]byte(text[4:4+end]), &v); err != nil {
+				return nil, fmt.Errorf("front-matter: %w", err)
+			}
+			return v, nil
+		}
+	}
+
+	blocks := jsonBlockRe.FindAllStringSubmatch(text, -1)
+	if len(blocks) > 0 {
+		if err := json.Unmarshal([]byte(blocks[len(blocks)-1][1]), &v); err != nil {
+			return nil, fmt.Errorf("JSON block: %w", err)
+		}
+		return v, nil
+	}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return nil, fmt.Errorf("JSON: %w", err)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("no front-matter or JSON block found")
+}
+
+// validate checks a parsed verdict against the schema. Enum values are
+// normalized to the spelling used in the schema.
+func (s *OutputSchema) validate(v map[string]interface{}) error {
+	for _, name := range s.names() {
+		f := s.Fields[name]
+		val, ok := v[name]
+		if !ok || val == nil {
+			if f.Required {
+				return fmt.Errorf("missing field %s", name)
+			}
+			continue
+		}
+		var typeOK bool
+		switch f.Type {
+		case "", "string":
+			_, typeOK = val.(string)
+		case "number":
+			switch val.(type) {
+			case float64, int:
+				typeOK = true
+			}
+		case "boolean":
+			_, typeOK = val.(bool)
+		case "array":
+			_, typeOK = val.([]interface{})
+		case "object":
+			_, typeOK = val.(map[string]interface{})
+		}
+		if !typeOK {
+			return fmt.Errorf("field %s: expected %s, got %T", name, f.Type, val)
+		}
+		if len(f.Enum) > 0 {
+			str, _ := val.(string)
+			found := false
+			for _, e := range f.Enum {
+				if strings.EqualFold(strings.TrimSpace(str), e) {
+					v[name] = e
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("field %s: %q is not one of %s", name, str, strings.Join(f.Enum, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// parse extracts and validates a verdict in one step.
+func (s *OutputSchema) parse(output string) (map[string]interface{}, error) {
+	v, err := extractVerdict(output)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validate(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkVerdict makes sure a stage with a schema produced a valid verdict.
+// On malformed output the backend is asked once to restate it; the reply
+// is appended to the result after verdictMarker.
+func checkVerdict(stage *Stage, ctx *WorkflowContext, result string) (string, error) {
+	if stage.Schema == nil {
+		return result, nil
+	}
+	_, err := stage.Schema.parse(result)
+	if err == nil {
+		return result, nil
+	}
+	fmt.Fprintf(ctx.output(), "%s Malformed verdict (%v), asking again\n", yellow("!"), err)
+	ctx.log("### Malformed verdict\n%v\n\n", err)
+
+	prompt := fmt.Sprintf(`Your previous reply could not be parsed: %v
+
+Restate its verdict as a JSON object in a single `+"```json"+` block with these fields:
+%s
+Reply with the JSON block only.
+
+## Previous reply
+%s`, err, stage.Schema.describe(), truncate(stripANSI(result), 8000))
+
+	backend := stage.Backend
+	if backend == "" || backend == "auto" {
+		backend = current
+	}
+	reply, callErr := ctx.callStage(backend, stage.Model, prompt, false)
+	if callErr != nil {
+		return result, fmt.Errorf("re-asking for verdict: %w", callErr)
+	}
+	if _, err := stage.Schema.parse(reply); err != nil {
+		return result, fmt.Errorf("stage %s: invalid verdict after retry: %w", stage.Name, err)
+	}
+	return result + verdictMarker + reply, nil
+}
+
+// verdict returns the parsed verdict of a stage with a schema, or nil if
+// the stage has no schema or hasn't produced a valid one.
+func (ctx *WorkflowContext) verdict(name string) map[string]interface{} {
+	stage := ctx.stage(name)
+	if stage == nil || stage.Schema == nil {
+		return nil
+	}
+	result, ok := ctx.Results[name]
+	if !ok {
+		return nil
+	}
+	v, err := stage.Schema.parse(verdictText(result))
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// verdictText is the part of a result holding the verdict: the retry reply
+// appended by checkVerdict if there is one, else the whole result.
+func verdictText(result string) string {
+	if i := strings.LastIndex(result, verdictMarker); i >= 0 {
+		return result[i+len(verdictMarker):]
+	}
+	return result
+}
+
+// stage looks up a stage of the running workflow by name.
+func (ctx *WorkflowContext) stage(name string) *Stage {
+	if ctx.Workflow == nil {
+		return nil
+	}
+	for i := range ctx.Workflow.Stages {
+		if ctx.Workflow.Stages[i].Name == name {
+			return &ctx.Workflow.Stages[i]
+		}
+	}
+	return nil
+}
+
+// verdictField resolves "stage.field" for conditions.
+func (ctx *WorkflowContext) verdictField(ref string) (interface{}, bool) {
+	name, field, ok := strings.Cut(ref, ".")
+	if !ok {
+		return nil, false
+	}
+	v := ctx.verdict(name)
+	if v == nil {
+		return nil, false
+	}
+	val, ok := v[field]
+	return val, ok
+}
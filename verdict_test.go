@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractVerdict(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]interface{}
+		err    string
+	}{
+		{
+			name:   "front-matter",
+			output: "---\nstatus: APPROVED\nrisk: low\n---\n# Review\nfine",
+			want:   map[string]interface{}{"status": "APPROVED", "risk": "low"},
+		},
+		{
+			name:   "front-matter after blank lines",
+			output: "\n\n---\nstatus: NEEDS_CHANGES\n---\n",
+			want:   map[string]interface{}{"status": "NEEDS_CHANGES"},
+		},
+		{
+			name:   "last JSON block wins",
+			output: "first\n```json\n{\"status\": \"NEEDS_CHANGES\"}\n```\nthen\n```json\n{\"status\": \"APPROVED\"}\n```\n",
+			want:   map[string]interface{}{"status": "APPROVED"},
+		},
+		{
+			name:   "unlabelled fence",
+			output: "```\n{\"ok\": true}\n```",
+			want:   map[string]interface{}{"ok": true},
+		},
+		{
+			name:   "bare JSON",
+			output: `{"count": 2}`,
+			want:   map[string]interface{}{"count": float64(2)},
+		},
+		{
+			name:   "ANSI codes are stripped",
+			output: "\x1b[32m{\"status\": \"APPROVED\"}\x1b[0m",
+			want:   map[string]interface{}{"status": "APPROVED"},
+		},
+		{name: "prose only", output: "Status: APPROVED", err: "no front-matter or JSON block"},
+		{name: "bad JSON block", output: "```json\n{\"a\": }\n```", err: "JSON block"},
+		{name: "bad front-matter", output: "---\n: [\n---\n", err: "front-matter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractVerdict(tt.output)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := &OutputSchema{Fields: map[string]SchemaField{
+		"status": {Enum: []string{"APPROVED", "NEEDS_CHANGES"}, Required: true},
+		"score":  {Type: "number"},
+		"issues": {Type: "array"},
+		"ok":     {Type: "boolean"},
+	}}
+	tests := []struct {
+		name   string
+		v      map[string]interface{}
+		status string // Normalized status after validation
+		err    string
+	}{
+		{"valid", map[string]interface{}{"status": "APPROVED", "score": float64(3), "issues": []interface{}{}, "ok": true}, "APPROVED", ""},
+		{"enum is case-insensitive", map[string]interface{}{"status": " approved "}, "APPROVED", ""},
+		{"missing required", map[string]interface{}{"score": float64(1)}, "", "missing field status"},
+		{"null required", map[string]interface{}{"status": nil}, "", "missing field status"},
+		{"not in enum", map[string]interface{}{"status": "MAYBE"}, "", `"MAYBE" is not one of APPROVED, NEEDS_CHANGES`},
+		{"wrong type", map[string]interface{}{"status": "APPROVED", "score": "high"}, "", "field score: expected number"},
+		{"array type", map[string]interface{}{"status": "APPROVED", "issues": "none"}, "", "field issues: expected array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.validate(tt.v)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.v["status"] != tt.status {
+				t.Errorf("status = %v, want %s", tt.v["status"], tt.status)
+			}
+		})
+	}
+}
+
+func TestVerdictAfterRetry(t *testing.T) {
+	ctx := &WorkflowContext{
+		Workflow: &Workflow{Stages: []Stage{{Name: "review", Schema: &OutputSchema{Fields: map[string]SchemaField{
+			"status": {Enum: []string{"APPROVED", "NEEDS_CHANGES"}, Required: true},
+		}}}}},
+		Results: map[string]string{
+			// The malformed first reply mentions APPROVED, the retry doesn't
+			"review": "looks APPROVED to me" + verdictMarker + "```json\n{\"status\": \"needs_changes\"}\n```",
+		},
+	}
+	if got := ctx.verdict("review"); got["status"] != "NEEDS_CHANGES" {
+		t.Errorf("verdict = %v, want the retry's status", got)
+	}
+	if val, ok := ctx.verdictField("review.status"); !ok || val != "NEEDS_CHANGES" {
+		t.Errorf("verdictField = %v, %v", val, ok)
+	}
+	if _, ok := ctx.verdictField("review.risk"); ok {
+		t.Error("verdictField found a field the verdict doesn't have")
+	}
+}
+
+func TestIsApproved(t *testing.T) {
+	tests := []struct {
+		review string
+		want   bool
+	}{
+		{"Status: APPROVED", true},
+		{"# Review\nAll good.\n\n**Status:** APPROVED", true},
+		{"APPROVED", true},
+		{"## Verdict: approved.", true},
+		{"---\nstatus: APPROVED\n---\nbody", true},
+		{"```json\n{\"status\": \"NEEDS_CHANGES\"}\n```\nAPPROVED", false},
+		{"This is not APPROVED yet", false},
+		{"Status: NEEDS_CHANGES", false},
+		{"Status: NEEDS_CHANGES\n\nfixed since\n\nStatus: APPROVED", true},
+		{"Status: APPROVED\nbut then\nStatus: NEEDS_CHANGES", false},
+		{"nothing here", false},
+	}
+	for _, tt := range tests {
+		if got := isApproved(tt.review); got != tt.want {
+			t.Errorf("isApproved(%q) = %v, want %v", tt.review, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,25 +12,41 @@ import (
 )
 
 type Stage struct {
-	Name        string            `json:"name"`
-	Backend     string            `json:"backend"`
-	Model       string            `json:"model,omitempty"`
-	Prompt      string            `json:"prompt"`
-	OutputFile  string            `json:"outputFile"`
-	Interactive bool              `json:"interactive"`
-	ReviewLoop  bool              `json:"reviewLoop"`
-	Skippable   bool              `json:"skippable,omitempty"`
-	Parallel    string            `json:"parallel,omitempty"`
-	Condition   string            `json:"condition,omitempty"`
-	MaxAttempts int               `json:"maxAttempts,omitempty"` // Default 3 if not set
-	Skill       string            `json:"skill,omitempty"`       // Reference to a skill
-	Inputs      map[string]string `json:"inputs,omitempty"`      // Inputs for skill
+	Name           string            `json:"name"`
+	Backend        string            `json:"backend"`
+	Model          string            `json:"model,omitempty"`
+	Prompt         string            `json:"prompt"`
+	OutputFile     string            `json:"outputFile"`
+	Interactive    bool              `json:"interactive"`
+	ReviewLoop     bool              `json:"reviewLoop,omitempty"` // Deprecated: use Workflow.Loops
+	Skippable      bool              `json:"skippable,omitempty"`
+	Parallel       string            `json:"parallel,omitempty"`       // Adjacent stages with the same group run concurrently
+	ParallelPolicy string            `json:"parallelPolicy,omitempty"` // "fail" (default) or "continue" when a branch fails
+	Condition      string            `json:"condition,omitempty"`
+	MaxAttempts    int               `json:"maxAttempts,omitempty"` // Default 3 if not set
+	Skill          string            `json:"skill,omitempty"`       // Reference to a skill
+	Inputs         map[string]string `json:"inputs,omitempty"`      // Inputs for skill or sub-workflow
+	Workflow       string            `json:"workflow,omitempty"`    // Run this workflow as the stage
+	DependsOn      []string          `json:"dependsOn,omitempty"`   // Stages that must finish first; makes the workflow a DAG
+	Schema         *OutputSchema     `json:"schema,omitempty"`      // Structured verdict the output must include
+	Type           string            `json:"type,omitempty"`        // "" (agent) or "shell"
+	ShellCommand                     // Command, dir, env and timeout of shell stages
+	Pre            []Hook            `json:"pre,omitempty"`    // Commands run before the stage
+	Post           []Hook            `json:"post,omitempty"`   // Commands run after it succeeds
+	Gate           bool              `json:"gate,omitempty"`   // Wait for the user to approve, reject or edit the output
+	Budget         *Budget           `json:"budget,omitempty"` // Limits for this stage across all its iterations
 }
 
 type Workflow struct {
-	Key    string  `json:"key"`
-	Name   string  `json:"name"`
-	Stages []Stage `json:"stages"`
+	Key      string       `json:"key"`
+	Name     string       `json:"name"`
+	Stages   []Stage      `json:"stages"`
+	Params   []Param      `json:"params,omitempty"` // Inputs besides the requirement
+	Loops    []Loop       `json:"loops,omitempty"`
+	Budget   *Budget      `json:"budget,omitempty"`   // Limits for the whole run
+	Extends  string       `json:"extends,omitempty"`  // Start from another workflow's stages
+	Patches  []StagePatch `json:"patches,omitempty"`  // Applied on top of Extends
+	Disabled bool         `json:"disabled,omitempty"` // Hide a built-in workflow
 }
 
 type WorkflowContext struct {
@@ -35,8 +54,26 @@ type WorkflowContext struct {
 	WorkDir        string
 	Results        map[string]string
 	CurrentIdx     int
-	LogFile        *os.File
+	LogFile        io.Writer
+	Output         io.Writer // Backend output; nil means stdout
 	BeforeSnapshot *FileSnapshot
+	Loops          map[string]int // Failed checks so far, by loop name
+	Skipped        []string       // Stages skipped by the user or a condition
+	Timer          *StageTimer
+	StartedAt      time.Time
+	Backend        string            // Session backend when the run started
+	Nodes          map[string]string // Per-stage status for DAG workflows
+	Outputs        map[string]string // Stage name -> saved output file
+	Workflow       *Workflow
+	Gates          []GateDecision    // Approval gate decisions, in order
+	Feedback       string            // Rejected output and feedback from a gate, for the re-run
+	Params         map[string]string // Workflow parameter values
+	Stack          []string          // Keys of the workflows running this one as a stage
+	Meter          *meter            // Usage against budgets; shared by copies of the context
+	Running        string            // Stage being executed, for budgets
+	Revisions      []Revision        // Restarts at a stage, oldest first
+	Lineage        *Lineage          // Set if the run is a fork of another
+	Manifest       *runManifest      // Records for manifest.json; shared by copies of the context
 }
 
 var defaultWorkflows = map[string]Workflow{
@@ -146,10 +183,15 @@ When done, type /quit or exit to continue to verification.`,
 ## Verification Results
 {{.VerifyContent}}
 
-Review the changes and output:
-# Code Review
+Review the changes. Start your reply with this front-matter, then the review:
 
-## Status: APPROVED / NEEDS_CHANGES
+---
+status: APPROVED or NEEDS_CHANGES
+risk: low, medium or high
+issues:
+  - "file:line: description and fix"
+---
+# Code Review
 
 ## Summary
 Brief overview
@@ -160,13 +202,17 @@ Brief overview
 ## Suggestions
 - Optional improvements
 
-If code is good and tests pass, say "Status: APPROVED".`,
+Use status APPROVED only if the code is good and tests pass.`,
+				Schema: &OutputSchema{Fields: map[string]SchemaField{
+					"status": {Enum: []string{"APPROVED", "NEEDS_CHANGES"}, Required: true},
+					"risk":   {Enum: []string{"low", "medium", "high"}},
+					"issues": {Type: "array"},
+				}},
 			},
 			{
 				Name:        "fix",
 				Backend:     "claude",
 				Interactive: true,
-				ReviewLoop:  true,
 				Prompt: `Fix these code review issues:
 
 {{.ReviewContent}}
@@ -176,6 +222,13 @@ Original requirement: {{.Requirement}}
 Address each issue listed. When done, exit to continue review.`,
 			},
 		},
+		Loops: []Loop{
+			{
+				Name:  "review",
+				Body:  []string{"code-review", "fix"},
+				Until: "approved:code-review",
+			},
+		},
 	},
 	"bugfix": {
 		Name: "Bug Fix",
@@ -365,7 +418,7 @@ Output:
 				Interactive: true,
 				Prompt: `Write tests based on this plan:
 
-{{.PlanContent}}
+{{.Stages.analyze.Output}}
 
 Requirement: {{.Requirement}}
 
@@ -405,7 +458,7 @@ Output:
 				Interactive: true,
 				Prompt: `Write documentation based on this outline:
 
-{{.PlanContent}}
+{{.Stages.scan.Output}}
 
 Requirement: {{.Requirement}}
 
@@ -441,7 +494,7 @@ Output:
 				Interactive: true,
 				Prompt: `Create Docker configuration:
 
-{{.PlanContent}}
+{{.Stages.analyze.Output}}
 
 Requirement: {{.Requirement}}
 
@@ -451,34 +504,69 @@ Create Dockerfile and docker-compose.yml if needed.`,
 				Name:       "verify",
 				Backend:    "kiro",
 				OutputFile: "docker-review.md",
-				Prompt: `Review Docker configuration for:
+				Prompt: `Review the Dockerfile and docker-compose.yml in this project for:
 - Security best practices
 - Image size optimization
 - Multi-stage builds
 - Proper layer caching
 
-{{.DiffContent}}
-
 Output issues and suggestions.`,
 			},
 		},
 	},
 }
 
+// validate checks parameters, the stage graph, conditions and loops before a
+// run starts.
+func (wf *Workflow) validate() error {
+	if err := wf.checkParams(); err != nil {
+		return err
+	}
+	if wf.isDAG() {
+		if _, err := wf.dagOrder(); err != nil {
+			return err
+		}
+	}
+	for i := range wf.Stages {
+		if err := wf.Stages[i].check(); err != nil {
+			return fmt.Errorf("stage %s: %w", wf.Stages[i].Name, err)
+		}
+	}
+	if err := wf.checkSubWorkflows([]string{wf.Key}); err != nil {
+		return err
+	}
+	if wf.Budget != nil {
+		if err := wf.Budget.check(); err != nil {
+			return err
+		}
+	}
+	if err := wf.checkConditions(); err != nil {
+		return err
+	}
+	_, err := wf.loopSpans()
+	return err
+}
+
 var dryRun bool
 
-func (wf *Workflow) Run(requirement string) error {
+// Run starts a new run. params holds parameter values given up front;
+// missing ones are asked for unless the run is non-interactive. It returns
+// the run directory, or "" if the run never got one.
+func (wf *Workflow) Run(requirement string, params map[string]string) (string, error) {
 	if dryRun {
-		return wf.DryRun(requirement)
+		return "", wf.DryRun(requirement)
 	}
-	baseDir := ".workflow"
-	timestamp := time.Now().Format("20060102_150405")
-	workDir := filepath.Join(baseDir, timestamp)
-	os.MkdirAll(workDir, 0755)
-
-	latestLink := filepath.Join(baseDir, "latest")
-	os.Remove(latestLink)
-	os.Symlink(timestamp, latestLink)
+	if err := wf.validate(); err != nil {
+		return "", fmt.Errorf("workflow %s: %w", wf.Key, err)
+	}
+	if err := checkLint(wf); err != nil {
+		return "", fmt.Errorf("workflow %s: %w", wf.Key, err)
+	}
+	params, err := wf.resolveParams(params, !runOpts.NonInteractive)
+	if err != nil {
+		return "", fmt.Errorf("workflow %s: %w", wf.Key, err)
+	}
+	workDir := newRunDir()
 
 	logPath := filepath.Join(workDir, "log.md")
 	logFile, _ := os.Create(logPath)
@@ -490,10 +578,21 @@ func (wf *Workflow) Run(requirement string) error {
 		Results:        make(map[string]string),
 		LogFile:        logFile,
 		BeforeSnapshot: takeSnapshot(),
+		Timer:          NewStageTimer(),
+		Loops:          make(map[string]int),
+		StartedAt:      time.Now(),
+		Backend:        current,
+		Workflow:       wf,
+		Params:         params,
+		Meter:          newMeter(wf, nil),
+		Manifest:       openManifest(workDir),
 	}
 
 	ctx.log("# Workflow: %s\n", wf.Name)
 	ctx.log("**Requirement:** %s\n", requirement)
+	if len(params) > 0 {
+		ctx.log("**Params:** %s\n", formatParams(params))
+	}
 	ctx.log("**Time:** %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
 
 	projectCtx := scanProjectContext()
@@ -502,338 +601,298 @@ func (wf *Workflow) Run(requirement string) error {
 
 	fmt.Printf("\n%s Workflow: %s\n", cyan("▶"), wf.Name)
 	fmt.Printf("%s Requirement: %s\n", dim("│"), requirement)
+	if len(params) > 0 {
+		fmt.Printf("%s Params: %s\n", dim("│"), formatParams(params))
+	}
 	fmt.Printf("%s Directory: %s\n", dim("│"), workDir)
 	fmt.Printf("%s Context: scanned project\n\n", dim("│"))
 
-	timer := NewStageTimer()
-	i := 0
-	reviewLoopCount := 0
+	return workDir, wf.execute(ctx, 0)
+}
 
-	for i < len(wf.Stages) {
-		stage := wf.Stages[i]
-		ctx.CurrentIdx = i
+// resumeWorkflow continues a run where it stopped, or with opts.From set,
+// restarts it at that stage.
+func resumeWorkflow(folder string, opts resumeOptions) error {
+	workDir, err := findRunDir(folder)
+	if err != nil {
+		return err
+	}
+
+	state, err := loadCheckpoint(workDir)
+	if err != nil {
+		return fmt.Errorf("cannot load checkpoint: %w", err)
+	}
+	if state.Status == statusCompleted && opts.From == "" {
+		return fmt.Errorf("workflow in %s already completed (use --from <stage> to run stages again)", workDir)
+	}
+
+	// Prefer the definition the run started with, so config edits made
+	// since then don't shift stage indexes under us.
+	wf := state.Definition
+	if wf == nil {
+		wf = getWorkflow(state.WorkflowName)
+	}
+	if wf == nil {
+		return fmt.Errorf("unknown workflow: %s", state.WorkflowName)
+	}
+
+	logFile, _ := os.OpenFile(filepath.Join(workDir, "log.md"), os.O_APPEND|os.O_WRONLY, 0644)
+	defer logFile.Close()
 
-		// Get maxAttempts (default 3)
-		maxAttempts := stage.MaxAttempts
-		if maxAttempts <= 0 {
-			maxAttempts = 3
+	ctx := restoreContext(state, logFile)
+	ctx.Workflow = wf
+	ctx.Meter = newMeter(wf, state.Usage)
+	ctx.Manifest = openManifest(workDir)
+	if state.Baseline == nil {
+		fmt.Printf("%s Checkpoint has no baseline snapshot, diff will only cover changes from now on\n", yellow("!"))
+	}
+
+	next := state.CurrentStage + 1
+	if state.NextStage != nil {
+		next = *state.NextStage
+	}
+	if opts.From != "" {
+		fmt.Printf("%s Restarting %s at %s\n", cyan("↻"), wf.Name, opts.From)
+		if next, err = wf.restartAt(ctx, opts); err != nil {
+			return err
 		}
+	}
 
-		if stage.ReviewLoop && reviewLoopCount == 0 {
-			reviewContent := ctx.Results["code-review"]
-			if strings.Contains(strings.ToUpper(reviewContent), "APPROVED") &&
-				!strings.Contains(strings.ToUpper(reviewContent), "NEEDS_CHANGES") {
-				fmt.Printf("%s Skipping fix (code approved)\n\n", green("✓"))
-				i++
-				continue
+	if wf.isDAG() {
+		done := 0
+		for _, st := range ctx.Nodes {
+			if st == nodeDone || st == nodeSkipped {
+				done++
 			}
 		}
+		fmt.Printf("%s Resuming: %s (%d/%d stages done)\n", cyan("↻"), wf.Name, done, len(wf.Stages))
+	} else {
+		fmt.Printf("%s Resuming: %s (stage %d/%d)\n", cyan("↻"), wf.Name, next+1, len(wf.Stages))
+	}
+	fmt.Printf("%s Directory: %s\n\n", dim("│"), workDir)
+	ctx.log("## Resumed at %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	// Stages without a backend of their own use the session backend the run
+	// started with, not whatever this session happens to be on.
+	if _, ok := config.Backends[ctx.Backend]; ok && ctx.Backend != current {
+		oldBackend := current
+		current = ctx.Backend
+		defer func() { current = oldBackend }()
+	}
+
+	return wf.execute(ctx, next)
+}
+
+// execute runs the stage loop from stage index start. It is shared by fresh
+// runs and resumes; everything it depends on lives in ctx and is saved to the
+// checkpoint before each stage, so a resumed run continues exactly where the
+// interrupted one stopped.
+func (wf *Workflow) execute(ctx *WorkflowContext, start int) error {
+	if wf.isDAG() {
+		return wf.executeDAG(ctx)
+	}
+	spans, err := wf.loopSpans()
+	if err != nil {
+		return fmt.Errorf("workflow %s: %w", wf.Key, err)
+	}
+	workDir := ctx.WorkDir
+	timer := ctx.Timer
+	i := start
+
+	for i < len(wf.Stages) {
+		stage := wf.Stages[i]
+		ctx.CurrentIdx = i
+		saveCheckpoint(ctx, wf, i, statusRunning)
 
 		progress := progressBar(i, len(wf.Stages), 20)
 		eta := timer.EstimateRemaining(i, len(wf.Stages))
 		fmt.Printf("%s %s ETA: %s\n", dim("│"), dim(progress), dim(eta))
 
+		if stage.Parallel != "" {
+			if end := parallelGroupEnd(wf.Stages, i); end-i > 1 {
+				if err := runParallelGroup(wf, ctx, i, end); err != nil {
+					if errors.Is(err, errStopped) {
+						return wf.stop(ctx, i, err)
+					}
+					saveCheckpoint(ctx, wf, i, statusFailed)
+					return err
+				}
+				i = end
+				continue
+			}
+		}
+
 		if stage.Condition != "" && !checkCondition(stage.Condition, ctx) {
 			fmt.Printf("%s [Stage %d/%d] %s - %s\n", dim("○"), i+1, len(wf.Stages), stage.Name, dim("skipped (condition not met)"))
+			ctx.skip(stage.Name)
 			i++
 			continue
 		}
 
-		fmt.Printf("%s [Stage %d/%d] %s (%s)\n", cyan("●"), i+1, len(wf.Stages), stage.Name, stage.Backend)
+		fmt.Printf("%s [Stage %d/%d] %s (%s)\n", cyan("●"), i+1, len(wf.Stages), stage.Name, stage.runner())
 
 		if stage.OutputFile != "" {
 			fmt.Printf("%s Output: %s\n", dim("│"), filepath.Join(workDir, stage.OutputFile))
 		}
 
 		if stage.Skippable {
-			fmt.Printf("%s Skip this stage? [y/N]: ", yellow("?"))
-			var input string
-			fmt.Scanln(&input)
-			if strings.ToLower(strings.TrimSpace(input)) == "y" {
+			if askSkip(fmt.Sprintf("%s Skip this stage? [y/N]: ", yellow("?"))) {
 				fmt.Printf("%s Skipped\n\n", dim("○"))
+				ctx.skip(stage.Name)
 				i++
 				continue
 			}
 		}
 
-		ctx.log("## Stage %d: %s (loop %d)\n\n", i+1, stage.Name, reviewLoopCount)
-
-		var result string
-		var err error
-
-		if stage.Backend == "auto" && stage.Name == "verify" {
-			afterSnapshot := takeSnapshot()
-			diffContent := ctx.BeforeSnapshot.Diff(afterSnapshot)
-			ctx.Results["diff"] = diffContent
-			os.WriteFile(filepath.Join(workDir, "diff.md"), []byte(diffContent), 0644)
-			fmt.Printf("%s Generated diff of changes\n", dim("│"))
-
-			verifyOutput, passed := runVerifyStage(workDir)
-			result = verifyOutput
-			ctx.Results["verify"] = result
-			if !passed {
-				fmt.Printf("%s Some checks failed, will be included in review\n", yellow("!"))
-			}
+		span := spanAt(spans, i)
+		if span != nil {
+			ctx.log("## Stage %d: %s (loop %s, iteration %d)\n\n", i+1, stage.Name, span.Name, ctx.Loops[span.Name]+1)
 		} else {
-			result, err = runStage(&stage, ctx)
-			if err != nil {
-				return fmt.Errorf("stage %s failed: %w", stage.Name, err)
-			}
+			ctx.log("## Stage %d: %s\n\n", i+1, stage.Name)
 		}
 
-		ctx.Results[stage.Name] = result
-
-		if stage.OutputFile != "" {
-			outPath := filepath.Join(workDir, fmt.Sprintf("%d.%s", i, stage.OutputFile))
-			cleanResult := stripANSI(result)
-			os.WriteFile(outPath, []byte(cleanResult), 0644)
-			fmt.Printf("%s Saved: %s\n", green("✓"), outPath)
+		result, extra, err := execStage(&stage, ctx)
+		if errors.Is(err, errStopped) {
+			return wf.stop(ctx, i, err)
+		}
+		if err != nil {
+			saveCheckpoint(ctx, wf, i, statusFailed)
+			return fmt.Errorf("stage %s failed: %w", stage.Name, err)
+		}
+		for k, v := range extra {
+			ctx.Results[k] = v
 		}
+		ctx.Results[stage.Name] = result
+		saveStageOutput(ctx, i, &stage, result)
 
 		ctx.log("### Output\n```\n%s\n```\n\n", truncate(result, 2000))
+		if stage.Gate {
+			if err := gateStage(ctx, i, &stage); err != nil {
+				saveCheckpoint(ctx, wf, i, statusFailed)
+				return fmt.Errorf("stage %s failed: %w", stage.Name, err)
+			}
+		}
 		timer.StageComplete()
 		fmt.Printf("%s Stage completed\n\n", green("✓"))
 
-		saveCheckpoint(ctx, wf.Key, i)
-
-		if stage.Name == "code-review" {
-			if strings.Contains(strings.ToUpper(result), "NEEDS_CHANGES") {
-				reviewLoopCount++
-				if reviewLoopCount >= maxAttempts {
-					fmt.Printf("%s Max review attempts reached (%d/%d)\n", yellow("!"), reviewLoopCount, maxAttempts)
-					fmt.Printf("%s Continue reviewing? [y/N] or [s]kip: ", yellow("?"))
-					var input string
-					fmt.Scanln(&input)
-					input = strings.ToLower(strings.TrimSpace(input))
-					if input == "y" {
-						reviewLoopCount = 0 // Reset counter
-						i++ // Go to fix stage
-						continue
-					} else if input == "s" {
-						fmt.Printf("%s Skipping fix stage\n\n", dim("○"))
-						i += 2 // Skip fix stage
-						continue
-					} else {
-						fmt.Printf("%s Stopping workflow\n", yellow("!"))
-						break
-					}
-				} else {
-					fmt.Printf("%s Changes needed, going to fix stage (attempt %d/%d)\n\n", yellow("↻"), reviewLoopCount, maxAttempts)
-					i++ // Go to fix stage
-					continue
-				}
-			} else {
-				fmt.Printf("%s Code approved!\n\n", green("✓"))
-				reviewLoopCount = 0
+		if span != nil {
+			next, stop, err := wf.loopNext(ctx, span, i)
+			if err != nil {
+				saveCheckpoint(ctx, wf, i, statusFailed)
+				return err
 			}
-		}
-
-		if stage.ReviewLoop && reviewLoopCount > 0 {
-			// Find code-review stage and go back
-			for j, s := range wf.Stages {
-				if s.Name == "code-review" {
-					i = j
-					fmt.Printf("%s Back to code review (attempt %d)\n\n", cyan("↻"), reviewLoopCount)
-					break
-				}
+			if stop {
+				saveCheckpoint(ctx, wf, next, statusStopped)
+				return nil
 			}
+			i = next
 			continue
 		}
 
 		i++
 	}
 
-	fmt.Printf("%s Workflow completed! (Total: %s)\n", green("✓"), formatDuration(timer.Elapsed()))
+	saveCheckpoint(ctx, wf, len(wf.Stages), statusCompleted)
+	wf.printCompleted(ctx)
+	return nil
+}
+
+// stop ends the run at stage next without failing it, so that resuming
+// starts there again.
+func (wf *Workflow) stop(ctx *WorkflowContext, next int, reason error) error {
+	fmt.Printf("%s Stopping workflow: %v\n", yellow("!"), reason)
+	ctx.log("### Stopped\n%v\n\n", reason)
+	saveCheckpoint(ctx, wf, next, statusStopped)
+	return nil
+}
+
+func (wf *Workflow) printCompleted(ctx *WorkflowContext) {
+	workDir := ctx.WorkDir
+	fmt.Printf("%s Workflow completed! (Total: %s)\n", green("✓"), formatDuration(ctx.Timer.Elapsed()))
+	if ctx.Meter != nil && ctx.Meter.Total.Calls > 0 {
+		fmt.Printf("%s Usage: %s\n", dim("│"), formatUsage(ctx.Meter.Total))
+	}
+	if len(ctx.Skipped) > 0 {
+		fmt.Printf("%s Skipped: %s\n", dim("○"), strings.Join(ctx.Skipped, ", "))
+	}
 	fmt.Printf("%s Files in: %s/\n", dim("📁"), workDir)
 
 	files, _ := os.ReadDir(workDir)
 	for _, f := range files {
 		fmt.Printf("   %s %s\n", dim("•"), f.Name())
 	}
-
-	return nil
 }
 
-func resumeWorkflow(folder string) error {
-	var workDir string
-	if folder != "" {
-		// Check if it's a full path or just folder name
-		if filepath.IsAbs(folder) {
-			workDir = folder
-		} else {
-			workDir = filepath.Join(".workflow", folder)
-		}
-		if _, err := os.Stat(workDir); os.IsNotExist(err) {
-			return fmt.Errorf("workflow folder not found: %s", workDir)
-		}
-	} else {
-		workDir = findLatestWorkflow()
-	}
-	if workDir == "" {
-		return fmt.Errorf("no workflow to resume")
+// execStage runs a single stage with its hooks and returns its output, plus
+// any other results it produced (the auto-verify stage also records the
+// diff). It only reads ctx.Results, so stages of a parallel group can share
+// one context.
+func execStage(stage *Stage, ctx *WorkflowContext) (result string, extra map[string]string, err error) {
+	ctx.Running = stage.Name
+	ctx.Manifest.begin(stage)
+	defer func() { ctx.Manifest.end(stage, result, err) }()
+	if err := runHooks(ctx, stage, "pre", stage.Pre); err != nil {
+		return "", nil, err
 	}
-
-	state, err := loadCheckpoint(workDir)
+	result, extra, err = execStageBody(stage, ctx)
 	if err != nil {
-		return fmt.Errorf("cannot load checkpoint: %w", err)
+		return result, extra, err
 	}
-
-	wf := getWorkflow(state.WorkflowName)
-	if wf == nil {
-		return fmt.Errorf("unknown workflow: %s", state.WorkflowName)
+	if err := runHooks(ctx, stage, "post", stage.Post); err != nil {
+		return result, extra, err
 	}
+	return result, extra, nil
+}
 
-	fmt.Printf("%s Resuming: %s (stage %d/%d)\n", cyan("↻"), wf.Name, state.CurrentStage+2, len(wf.Stages))
-	fmt.Printf("%s Directory: %s\n\n", dim("│"), workDir)
-
-	logFile, _ := os.OpenFile(filepath.Join(workDir, "log.md"), os.O_APPEND|os.O_WRONLY, 0644)
-	defer logFile.Close()
-
-	ctx := &WorkflowContext{
-		Requirement:    state.Requirement,
-		WorkDir:        workDir,
-		Results:        state.Results,
-		LogFile:        logFile,
-		BeforeSnapshot: takeSnapshot(),
+func execStageBody(stage *Stage, ctx *WorkflowContext) (string, map[string]string, error) {
+	if stage.Workflow != "" {
+		return runSubWorkflow(stage, ctx)
 	}
-
-	timer := NewStageTimer()
-	reviewLoopCount := state.ReviewAttempts
-	i := state.CurrentStage + 1
-
-	for i < len(wf.Stages) {
-		stage := wf.Stages[i]
-		ctx.CurrentIdx = i
-
-		maxAttempts := stage.MaxAttempts
-		if maxAttempts <= 0 {
-			maxAttempts = 3
-		}
-
-		if stage.ReviewLoop && reviewLoopCount == 0 {
-			reviewContent := ctx.Results["code-review"]
-			if strings.Contains(strings.ToUpper(reviewContent), "APPROVED") &&
-				!strings.Contains(strings.ToUpper(reviewContent), "NEEDS_CHANGES") {
-				fmt.Printf("%s Skipping fix (code approved)\n\n", green("✓"))
-				i++
-				continue
-			}
-		}
-
-		progress := progressBar(i, len(wf.Stages), 20)
-		eta := timer.EstimateRemaining(i, len(wf.Stages))
-		fmt.Printf("%s %s ETA: %s\n", dim("│"), dim(progress), dim(eta))
-
-		if stage.Condition != "" && !checkCondition(stage.Condition, ctx) {
-			fmt.Printf("%s [Stage %d/%d] %s - %s\n", dim("○"), i+1, len(wf.Stages), stage.Name, dim("skipped (condition not met)"))
-			i++
-			continue
+	if stage.Type == "shell" {
+		result, err := stage.ShellCommand.run(ctx, stage.Name, ctx.output())
+		if err != nil {
+			return result, nil, err
 		}
-
-		fmt.Printf("%s [Stage %d/%d] %s (%s)\n", cyan("●"), i+1, len(wf.Stages), stage.Name, stage.Backend)
-
-		if stage.OutputFile != "" {
-			fmt.Printf("%s Output: %s\n", dim("│"), filepath.Join(workDir, stage.OutputFile))
-		}
-
-		if stage.Skippable {
-			fmt.Printf("%s Skip this stage? [y/N]: ", yellow("?"))
-			var input string
-			fmt.Scanln(&input)
-			if strings.ToLower(strings.TrimSpace(input)) == "y" {
-				fmt.Printf("%s Skipped\n\n", dim("○"))
-				i++
-				continue
-			}
-		}
-
-		ctx.log("## Stage %d: %s (resumed, loop %d)\n\n", i+1, stage.Name, reviewLoopCount)
-
-		var result string
-
-		if stage.Backend == "auto" && stage.Name == "verify" {
-			afterSnapshot := takeSnapshot()
-			diffContent := ctx.BeforeSnapshot.Diff(afterSnapshot)
-			ctx.Results["diff"] = diffContent
-			os.WriteFile(filepath.Join(workDir, "diff.md"), []byte(diffContent), 0644)
-			fmt.Printf("%s Generated diff of changes\n", dim("│"))
-
-			verifyOutput, passed := runVerifyStage(workDir)
-			result = verifyOutput
-			ctx.Results["verify"] = result
-			if !passed {
-				fmt.Printf("%s Some checks failed, will be included in review\n", yellow("!"))
-			}
-		} else {
-			var err error
-			result, err = runStage(&stage, ctx)
-			if err != nil {
-				return fmt.Errorf("stage %s failed: %w", stage.Name, err)
-			}
-		}
-
-		ctx.Results[stage.Name] = result
-
-		if stage.OutputFile != "" {
-			outPath := filepath.Join(workDir, fmt.Sprintf("%d.%s", i, stage.OutputFile))
-			os.WriteFile(outPath, []byte(stripANSI(result)), 0644)
-			fmt.Printf("%s Saved: %s\n", green("✓"), outPath)
-		}
-
-		ctx.log("### Output\n```\n%s\n```\n\n", truncate(result, 2000))
-		timer.StageComplete()
-		fmt.Printf("%s Stage completed\n\n", green("✓"))
-
-		saveCheckpointWithAttempts(ctx, wf.Key, i, reviewLoopCount)
-
-		if stage.Name == "code-review" {
-			if strings.Contains(strings.ToUpper(result), "NEEDS_CHANGES") {
-				reviewLoopCount++
-				if reviewLoopCount >= maxAttempts {
-					fmt.Printf("%s Max review attempts reached (%d/%d)\n", yellow("!"), reviewLoopCount, maxAttempts)
-					fmt.Printf("%s Continue reviewing? [y/N] or [s]kip: ", yellow("?"))
-					var input string
-					fmt.Scanln(&input)
-					input = strings.ToLower(strings.TrimSpace(input))
-					if input == "y" {
-						reviewLoopCount = 0
-						i++
-						continue
-					} else if input == "s" {
-						fmt.Printf("%s Skipping fix stage\n\n", dim("○"))
-						i += 2
-						continue
-					} else {
-						fmt.Printf("%s Stopping workflow\n", yellow("!"))
-						break
-					}
-				} else {
-					fmt.Printf("%s Changes needed, going to fix stage (attempt %d/%d)\n\n", yellow("↻"), reviewLoopCount, maxAttempts)
-					i++
-					continue
-				}
-			} else {
-				fmt.Printf("%s Code approved!\n\n", green("✓"))
-				reviewLoopCount = 0
-			}
-		}
-
-		if stage.ReviewLoop && reviewLoopCount > 0 {
-			for j, s := range wf.Stages {
-				if s.Name == "code-review" {
-					i = j
-					fmt.Printf("%s Back to code review (attempt %d)\n\n", cyan("↻"), reviewLoopCount)
-					break
-				}
-			}
-			continue
+		result, err = checkVerdict(stage, ctx, result)
+		return result, nil, err
+	}
+	if stage.Backend == "auto" && stage.Name == "verify" {
+		afterSnapshot := takeSnapshot()
+		diffContent := ctx.BeforeSnapshot.Diff(afterSnapshot)
+		os.WriteFile(filepath.Join(ctx.WorkDir, "diff.md"), []byte(diffContent), 0644)
+		fmt.Fprintf(ctx.output(), "%s Generated diff of changes\n", dim("│"))
+
+		verifyOutput, passed := runVerifyStage(ctx.WorkDir)
+		if !passed {
+			fmt.Fprintf(ctx.output(), "%s Some checks failed, will be included in review\n", yellow("!"))
 		}
+		return verifyOutput, map[string]string{"diff": diffContent, "verify": verifyOutput}, nil
+	}
+	result, err := runStage(stage, ctx)
+	if err != nil {
+		return result, nil, err
+	}
+	result, err = checkVerdict(stage, ctx, result)
+	return result, nil, err
+}
 
-		i++
+func saveStageOutput(ctx *WorkflowContext, idx int, stage *Stage, result string) {
+	if stage.OutputFile == "" {
+		return
 	}
+	outPath := filepath.Join(ctx.WorkDir, fmt.Sprintf("%d.%s", idx, stage.OutputFile))
+	os.WriteFile(outPath, []byte(stripANSI(result)), 0644)
+	if ctx.Outputs == nil {
+		ctx.Outputs = make(map[string]string)
+	}
+	ctx.Outputs[stage.Name] = outPath
+	ctx.Manifest.output(stage.Name, outPath, stripANSI(result))
+	fmt.Printf("%s Saved: %s\n", green("✓"), outPath)
+}
 
-	fmt.Printf("%s Workflow resumed and completed!\n", green("✓"))
-	return nil
+func (ctx *WorkflowContext) skip(stage string) {
+	ctx.Skipped = append(ctx.Skipped, stage)
+	ctx.Manifest.skip(stage)
 }
 
 func (ctx *WorkflowContext) log(format string, args ...interface{}) {
@@ -864,77 +923,58 @@ func runStage(stage *Stage, ctx *WorkflowContext) (string, error) {
 		// Build inputs from stage.Inputs with variable substitution
 		inputs := make(map[string]string)
 		for k, v := range stage.Inputs {
-			v = strings.ReplaceAll(v, "{{.Requirement}}", ctx.Requirement)
-			v = strings.ReplaceAll(v, "{{.DiffContent}}", ctx.Results["diff"])
-			v = strings.ReplaceAll(v, "{{.ProjectContext}}", ctx.Results["project-context"])
-			inputs[k] = v
+			value, err := renderTemplate(stageSource(ctx, stage)+" input "+k, v, promptData(ctx))
+			if err != nil {
+				return "", err
+			}
+			inputs[k] = value
 		}
 
-		// Override skill settings if stage specifies them
+		// Override skill settings if stage specifies them, on a copy so
+		// the loaded skill is left alone
+		sk := *skill
 		if stage.Backend != "" {
-			skill.Stage.Backend = stage.Backend
+			sk.Stage.Backend = stage.Backend
 		}
 		if stage.Model != "" {
-			skill.Stage.Model = stage.Model
+			sk.Stage.Model = stage.Model
 		}
 
-		return skill.Run(inputs, ctx)
+		return sk.Run(inputs, ctx)
 	}
 
-	prompt := stage.Prompt
-	prompt = strings.ReplaceAll(prompt, "{{.Requirement}}", ctx.Requirement)
-	prompt = strings.ReplaceAll(prompt, "{{.ProjectContext}}", ctx.Results["project-context"])
-
-	planContent := findOutputFile(ctx.WorkDir, "plan.md")
-	if len(planContent) == 0 {
-		planContent = findOutputFile(ctx.WorkDir, "analysis.md")
-	}
-	if len(planContent) == 0 {
-		planContent = findOutputFile(ctx.WorkDir, "api-plan.md")
-	}
-	if len(planContent) == 0 {
-		planContent = findOutputFile(ctx.WorkDir, "refactor-plan.md")
-	}
-	prompt = strings.ReplaceAll(prompt, "{{.PlanContent}}", string(planContent))
-
-	tasksContent := findOutputFile(ctx.WorkDir, "tasks.md")
-	if len(tasksContent) == 0 {
-		tasksContent = findOutputFile(ctx.WorkDir, "fix-tasks.md")
-	}
-	if len(tasksContent) == 0 {
-		tasksContent = findOutputFile(ctx.WorkDir, "refactor-tasks.md")
+	prompt, err := renderTemplate(stageSource(ctx, stage), stage.Prompt, promptData(ctx))
+	if err != nil {
+		return "", err
 	}
-	prompt = strings.ReplaceAll(prompt, "{{.TasksContent}}", string(tasksContent))
-
-	reviewContent := findOutputFile(ctx.WorkDir, "review.md")
-	prompt = strings.ReplaceAll(prompt, "{{.ReviewContent}}", string(reviewContent))
-
-	verifyContent := findOutputFile(ctx.WorkDir, "verify.md")
-	prompt = strings.ReplaceAll(prompt, "{{.VerifyContent}}", string(verifyContent))
-
-	diffContent := findOutputFile(ctx.WorkDir, "diff.md")
-	prompt = strings.ReplaceAll(prompt, "{{.DiffContent}}", string(diffContent))
-
-	securityContent := findOutputFile(ctx.WorkDir, "security.md")
-	prompt = strings.ReplaceAll(prompt, "{{.SecurityContent}}", string(securityContent))
+	prompt = ctx.withFeedback(prompt)
 
 	ctx.log("### Prompt\n```\n%s\n```\n\n", truncate(prompt, 1000))
 
-	oldBackend := current
-	oldModel := currentModel
-	current = stage.Backend
-	currentModel = stage.Model // Set model for this stage
+	backend := stage.Backend
+	if backend == "" {
+		backend = current
+	}
 
-	var result string
 	if stage.Interactive {
-		result = callInteractive(prompt)
-	} else {
-		result = call(prompt)
+		tty, err := interactiveStage(stage.Name)
+		if err != nil {
+			return "", err
+		}
+		if tty {
+			return ctx.callStage(backend, stage.Model, prompt, true)
+		}
 	}
+	return ctx.callStage(backend, stage.Model, prompt, false)
+}
 
-	current = oldBackend
-	currentModel = oldModel
-	return result, nil
+// output is where non-interactive backend output is streamed: the terminal,
+// or a branch log when the stage runs in a parallel group.
+func (ctx *WorkflowContext) output() io.Writer {
+	if ctx == nil || ctx.Output == nil {
+		return os.Stdout
+	}
+	return ctx.Output
 }
 
 func getWorkflow(name string) *Workflow {
@@ -962,49 +1002,40 @@ func listWorkflows() {
 			if s.OutputFile != "" {
 				out = fmt.Sprintf(" → %s", s.OutputFile)
 			}
-			fmt.Printf("    %d. %s (%s)%s%s%s\n", i+1, s.Name, s.Backend, out, inter, skip)
+			fmt.Printf("    %d. %s (%s)%s%s%s\n", i+1, s.Name, s.runner(), out, inter, skip)
 		}
 	}
 }
 
-func showWorkflowHistory() {
-	baseDir := ".workflow"
-	entries, err := os.ReadDir(baseDir)
-	if err != nil {
-		fmt.Println(dim("No workflow history"))
+// showWorkflow prints the fully merged definition of a workflow, after
+// project overrides and extends/patches have been applied.
+func showWorkflow(name string) {
+	wf := getWorkflow(name)
+	if wf == nil {
+		fmt.Printf("%s Unknown workflow: %s\n", yellow("!"), name)
 		return
 	}
-
-	fmt.Println(cyan("Workflow History:"))
-	count := 0
-	for i := len(entries) - 1; i >= 0 && count < 10; i-- {
-		e := entries[i]
-		if !e.IsDir() || e.Name() == "latest" {
-			continue
-		}
-		statePath := filepath.Join(baseDir, e.Name(), "state.json")
-		if state, err := loadCheckpoint(filepath.Join(baseDir, e.Name())); err == nil {
-			fmt.Printf("  %s %s - %s (stage %d)\n", dim(e.Name()), green(state.WorkflowName), state.Requirement[:min(40, len(state.Requirement))], state.CurrentStage+1)
-		} else if _, err := os.Stat(statePath); err == nil {
-			fmt.Printf("  %s\n", dim(e.Name()))
-		}
-		count++
-	}
-	if count == 0 {
-		fmt.Println(dim("No workflow history"))
-	}
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+	fmt.Printf("%s %s - %s\n", cyan("Workflow:"), green(name), wf.Name)
+	printOrigin(workflowOrigins[name])
+	// The whole definition as it will run, with extends and patches applied
+	data, _ := json.MarshalIndent(wf, "", "  ")
+	fmt.Println(string(data))
 }
 
 func (wf *Workflow) DryRun(requirement string) error {
 	fmt.Printf("\n%s DRY RUN: %s\n", yellow("▶"), wf.Name)
-	fmt.Printf("%s Requirement: %s\n\n", dim("│"), requirement)
+	fmt.Printf("%s Requirement: %s\n", dim("│"), requirement)
+	for _, p := range wf.Params {
+		req := ""
+		if p.Required {
+			req = yellow(" (required)")
+		}
+		fmt.Printf("%s Param %s%s %s\n", dim("│"), p.Name, req, dim(p.Description))
+	}
+	if wf.Budget != nil {
+		fmt.Printf("%s Budget: %s\n", dim("│"), wf.Budget)
+	}
+	fmt.Println()
 
 	for i, stage := range wf.Stages {
 		skip := ""
@@ -1015,10 +1046,27 @@ func (wf *Workflow) DryRun(requirement string) error {
 		if stage.Interactive {
 			inter = cyan(" (interactive)")
 		}
-		fmt.Printf("%s Stage %d: %s (%s)%s%s\n", dim("│"), i+1, stage.Name, stage.Backend, inter, skip)
+		par := ""
+		if stage.Parallel != "" {
+			par = dim(" [parallel: " + stage.Parallel + "]")
+		}
+		fmt.Printf("%s Stage %d: %s (%s)%s%s%s\n", dim("│"), i+1, stage.Name, stage.runner(), inter, skip, par)
+		if len(stage.DependsOn) > 0 {
+			fmt.Printf("%s   after: %s\n", dim("│"), strings.Join(stage.DependsOn, ", "))
+		}
 		if stage.OutputFile != "" {
 			fmt.Printf("%s   → %s\n", dim("│"), stage.OutputFile)
 		}
+		if stage.Budget != nil {
+			fmt.Printf("%s   budget: %s\n", dim("│"), stage.Budget)
+		}
+	}
+	for _, issue := range wf.lintWithSubs() {
+		mark := yellow("!")
+		if issue.Severity == lintError {
+			mark = red("✗")
+		}
+		fmt.Printf("%s %s: %s\n", mark, issue.Workflow, issue)
 	}
 
 	fmt.Printf("\n%s This is a dry run. No changes will be made.\n", yellow("!"))